@@ -2,17 +2,23 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"go-gin-example/internal/config"
+	"go-gin-example/internal/hub"
 	"go-gin-example/internal/server"
+
+	"github.com/go-stomp/stomp/v3"
 )
 
-func gracefulShutdown(apiServer *http.Server, done chan bool) {
+func gracefulShutdown(apiServer *http.Server, h *hub.Hub, timeout time.Duration, done chan bool) {
 	// Create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -23,13 +29,20 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	log.Println("shutting down gracefully, press Ctrl+C again to force")
 	stop() // Allow Ctrl+C to force shutdown
 
-	// The context is used to inform the server it has 5 seconds to finish
-	// the request it is currently handling
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// One deadline covers both steps. The hub starts draining first so new
+	// upgrades get a 503 while HTTP requests in flight finish; hijacked
+	// sockets are not tracked by the HTTP server, the hub closes them with
+	// CloseGoingAway once their buffers are flushed.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- h.Stop(ctx) }()
 	if err := apiServer.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown with error: %v", err)
 	}
+	if err := <-stopped; err != nil {
+		log.Printf("Hub forced to stop: %v", err)
+	}
 
 	log.Println("Server exiting")
 
@@ -37,6 +50,74 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	done <- true
 }
 
+// startBroker connects the hub to the STOMP broker and, when enabled, the
+// cluster bus. A failed first connect aborts startup under PolicyFail and
+// is retried in the background under PolicyDegrade.
+func startBroker(h *hub.Hub, cfg *config.Config) error {
+	sc := cfg.STOMP
+	if sc.Broker == "" {
+		log.Println("STOMP disabled: no broker configured")
+		return nil
+	}
+
+	ackModes := map[string]stomp.AckMode{
+		"auto":              stomp.AckAuto,
+		"client":            stomp.AckClient,
+		"client-individual": stomp.AckClientIndividual,
+	}
+	var dests []hub.Destination
+	for _, sub := range sc.AllSubscriptions() {
+		d, err := hub.DestinationOf(sub.Kind, sub.Destination, ackModes[sub.AckMode], sub.Headers)
+		if err != nil {
+			return err
+		}
+		d.DeadLetter = sub.DeadLetter
+		d.MaxRedeliveries = sc.MaxRedeliveries
+		d.RewritesMessageIDs = sc.RewritesMessageIDs
+		dests = append(dests, d)
+	}
+
+	opts := []func(*stomp.Conn) error{
+		stomp.ConnOpt.HeartBeat(sc.HeartBeatSend, sc.HeartBeatRecv),
+		stomp.ConnOpt.RcvReceiptTimeout(sc.ReceiptTimeout),
+	}
+	if sc.Login != "" {
+		opts = append(opts, stomp.ConnOpt.Login(sc.Login, sc.Passcode))
+	}
+	if sc.VHost != "" {
+		opts = append(opts, stomp.ConnOpt.Host(sc.VHost))
+	}
+
+	h.SetPublishing(hub.PublishConfig{
+		Destination: sc.PublishDestination,
+		Routes:      sc.PublishRoutes,
+		Receipts:    sc.PublishReceipts,
+		Allowed:     sc.PublishAllowed,
+	})
+	if err := h.StartSTOMP(sc.Broker, dests, opts...); err != nil {
+		if sc.StartupPolicy == config.PolicyFail {
+			h.StopSTOMP()
+			return fmt.Errorf("connect to STOMP broker %s: %w", sc.Broker, err)
+		}
+		log.Printf("STOMP broker %s unavailable, continuing degraded: %v", sc.Broker, err)
+	}
+
+	if cfg.Cluster.Enabled {
+		bus, err := h.STOMPBus(cfg.Cluster.Prefix)
+		if err != nil {
+			return err
+		}
+		nodeID := cfg.Cluster.NodeID
+		if nodeID == "" {
+			nodeID, _ = os.Hostname()
+		}
+		if err := h.EnableCluster(bus, nodeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // server/server.go  (or wherever RegisterRoutes lives)
 
 // @title           My Project API
@@ -50,16 +131,50 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 // @host      localhost:31073
 // @BasePath  /
 func main() {
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, secrets redacted, and exit")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if *printConfig {
+		fmt.Println(cfg)
+		return
+	}
+	log.Printf("Effective configuration:\n%s", cfg)
+	opts := hub.Options{
+		DisableOffline: !cfg.Offline.Enabled,
+		ResumeBuffer:   cfg.Resume.Buffer,
+		ResumeTTL:      cfg.Resume.TTL,
+		SlowConsumer: map[string]hub.SlowConsumerPolicy{
+			hub.EndpointWS:    hub.SlowConsumerPolicy(cfg.SlowConsumer.WS),
+			hub.EndpointSTOMP: hub.SlowConsumerPolicy(cfg.SlowConsumer.STOMP),
+		},
+	}
+	if cfg.Offline.Enabled {
+		opts.Offline = hub.NewMemoryOfflineStore(cfg.Offline.MaxPerUser, cfg.Offline.TTL)
+	}
+	h := hub.New(opts)
+	if err := h.Start(context.Background()); err != nil {
+		log.Fatalf("startup failed: %v", err)
+	}
+	if err := startBroker(h, cfg); err != nil {
+		log.Fatalf("startup failed: %v", err)
+	}
 
-	server := server.NewServer()
+	server, err := server.NewServer(h, cfg, server.Options{})
+	if err != nil {
+		log.Fatalf("startup failed: %v", err)
+	}
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
 
 	// Run graceful shutdown in a separate goroutine
-	go gracefulShutdown(server, done)
+	go gracefulShutdown(server, h, cfg.ShutdownTimeout, done)
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		panic(fmt.Sprintf("http server error: %s", err))
 	}
@@ -0,0 +1,601 @@
+// Package config loads service settings from the environment (including the
+// .env file autoloaded by godotenv) and an optional YAML or TOML file.
+// Environment variables win over the file, which wins over the defaults.
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Startup policies for the STOMP broker.
+const (
+	PolicyFail    = "fail"    // refuse to start when the first connect fails
+	PolicyDegrade = "degrade" // start anyway and keep reconnecting in the background
+)
+
+// defaultConfigFiles are read, first found wins, when CONFIG_FILE is unset.
+var defaultConfigFiles = []string{"config.yaml", "config.toml"}
+
+// EnvProduction is the APP_ENV under which weak settings are refused.
+const EnvProduction = "production"
+
+type Config struct {
+	Env  string `yaml:"env"`  // APP_ENV
+	Port int    `yaml:"port"` // PORT
+
+	STOMP   STOMP   `yaml:"stomp"`
+	Cluster Cluster `yaml:"cluster"`
+	Offline Offline `yaml:"offline"`
+	Resume  Resume  `yaml:"resume"`
+
+	SlowConsumer SlowConsumer `yaml:"slow_consumer"`
+	Auth         Auth         `yaml:"auth"`
+
+	// ShutdownTimeout bounds the graceful shutdown: in-flight requests
+	// finish and sockets flush what is buffered before the going-away close.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// STOMP configures broker ingestion. An empty Broker disables it.
+type STOMP struct {
+	Broker             string            `yaml:"broker"` // host:port
+	Login              string            `yaml:"login"`
+	Passcode           string            `yaml:"passcode"`
+	VHost              string            `yaml:"vhost"`
+	Destinations       []string          `yaml:"destinations"` // "dest" or "dest=kind"
+	Subscriptions      []Subscription    `yaml:"subscriptions"`
+	AckMode            string            `yaml:"ack_mode"` // auto | client | client-individual
+	HeartBeatSend      time.Duration     `yaml:"heartbeat_send"`
+	HeartBeatRecv      time.Duration     `yaml:"heartbeat_recv"`
+	PublishDestination string            `yaml:"publish_destination"`
+	PublishRoutes      map[string]string `yaml:"publish_routes"` // event type → destination
+	PublishReceipts    bool              `yaml:"publish_receipts"`
+	PublishAllowed     []string          `yaml:"publish_allowed"` // further destinations callers may name
+	ReceiptTimeout     time.Duration     `yaml:"receipt_timeout"`
+	StartupPolicy      string            `yaml:"startup_policy"` // fail | degrade
+	// DeadLetter receives frames that cannot be decoded or keep failing
+	// after MaxRedeliveries NACKs. Empty drops them after logging.
+	DeadLetter      string `yaml:"dead_letter"`
+	MaxRedeliveries int    `yaml:"max_redeliveries"`
+	// RewritesMessageIDs counts redeliveries per frame body instead of
+	// message-id, for brokers that assign a new message-id on every
+	// redelivery.
+	RewritesMessageIDs bool `yaml:"rewrites_message_ids"`
+}
+
+// Subscription is one broker destination and the event family it carries.
+type Subscription struct {
+	Destination string            `yaml:"destination"`
+	Kind        string            `yaml:"kind"`        // chat | presence | system
+	AckMode     string            `yaml:"ack_mode"`    // defaults to stomp.ack_mode
+	DeadLetter  string            `yaml:"dead_letter"` // defaults to stomp.dead_letter
+	Selector    string            `yaml:"selector"`
+	Headers     map[string]string `yaml:"headers"`
+}
+
+var subscriptionKinds = map[string]bool{"chat": true, "presence": true, "system": true}
+
+// AllSubscriptions merges the Destinations shorthand with Subscriptions and
+// fills in the kind and ack mode defaults.
+func (s STOMP) AllSubscriptions() []Subscription {
+	out := make([]Subscription, 0, len(s.Destinations)+len(s.Subscriptions))
+	for _, d := range s.Destinations {
+		dest, kind, _ := strings.Cut(d, "=")
+		out = append(out, Subscription{Destination: strings.TrimSpace(dest), Kind: strings.TrimSpace(kind)})
+	}
+	out = append(out, s.Subscriptions...)
+	for i := range out {
+		if out[i].Kind == "" {
+			out[i].Kind = "chat"
+		}
+		if out[i].AckMode == "" {
+			out[i].AckMode = s.AckMode
+		}
+		if out[i].DeadLetter == "" {
+			out[i].DeadLetter = s.DeadLetter
+		}
+		if out[i].Selector != "" {
+			headers := map[string]string{"selector": out[i].Selector}
+			for k, v := range out[i].Headers {
+				headers[k] = v
+			}
+			out[i].Headers = headers
+		}
+	}
+	return out
+}
+
+// Cluster configures cross-instance delivery over the broker.
+type Cluster struct {
+	Enabled bool   `yaml:"enabled"`
+	NodeID  string `yaml:"node_id"`
+	Prefix  string `yaml:"prefix"`
+}
+
+// Offline configures the per-user queue for recipients without a socket.
+type Offline struct {
+	Enabled    bool          `yaml:"enabled"`
+	MaxPerUser int           `yaml:"max_per_user"`
+	TTL        time.Duration `yaml:"ttl"`
+}
+
+// Resume sizes the per-user replay ring used by reconnecting clients.
+type Resume struct {
+	Buffer int           `yaml:"buffer"` // events kept per user
+	TTL    time.Duration `yaml:"ttl"`    // how long an idle user's sequence is kept
+}
+
+// SlowConsumer picks, per endpoint, what happens when a socket's send
+// buffer is full: drop, drop-oldest, disconnect or spill.
+type SlowConsumer struct {
+	WS    string `yaml:"ws"`
+	STOMP string `yaml:"stomp"`
+}
+
+// Auth configures how callers are identified.
+type Auth struct {
+	// Secrets are the HS512 keys of the tokens issued by /signin. The first
+	// signs new tokens; all of them verify, so a key is rotated by putting
+	// the new one first and dropping the old one once its tokens expired.
+	Secrets []string `yaml:"secrets"`
+
+	// AccessTTL and RefreshTTL bound the tokens issued by /signin. A refresh
+	// token is single-use: refreshing returns a new pair.
+	AccessTTL  time.Duration `yaml:"access_ttl"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl"`
+
+	// Strict rejects requests without a valid identity with 401, before any
+	// WebSocket upgrade. Off, they run as the anonymous all-zero user, which
+	// is only meant for local development.
+	Strict bool `yaml:"strict"`
+
+	// Admins are the user IDs allowed on the admin routes, besides holders
+	// of a token with the "admin" role.
+	Admins []string `yaml:"admins"`
+
+	Gateway Gateway `yaml:"gateway"`
+	JWT     JWT     `yaml:"jwt"`
+}
+
+// JWT configures the identity provider whose tokens are accepted besides
+// the HS512 tokens of /signin. Its keys come from PEM files, a JWKS file
+// and/or a JWKS URL; with none set only the service's own tokens count.
+type JWT struct {
+	Algorithms   []string      `yaml:"algorithms"` // defaults to RS256, ES256, EdDSA
+	Issuer       string        `yaml:"issuer"`
+	Audience     string        `yaml:"audience"`
+	PublicKeys   []string      `yaml:"public_keys"` // PEM files
+	JWKSFile     string        `yaml:"jwks_file"`
+	JWKSURL      string        `yaml:"jwks_url"`
+	JWKSCacheTTL time.Duration `yaml:"jwks_cache_ttl"`
+}
+
+// Enabled reports whether an identity provider is configured.
+func (j JWT) Enabled() bool {
+	return len(j.PublicKeys) > 0 || j.JWKSFile != "" || j.JWKSURL != ""
+}
+
+var jwtAlgorithms = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+// Gateway decides when the X-Ws-User-Id / X-User-Id identity headers are
+// honored. With TrustedProxies the request must come straight from one of
+// those networks; with Secret it must carry a fresh HMAC signature of the
+// user ID. Set both to require both. With neither, the headers are only
+// honored when auth is not strict.
+type Gateway struct {
+	TrustedProxies []string      `yaml:"trusted_proxies"` // CIDRs
+	Secret         string        `yaml:"secret"`
+	MaxSkew        time.Duration `yaml:"max_skew"` // accepted signature age
+}
+
+var slowConsumerPolicies = map[string]bool{
+	"drop":        true,
+	"drop-oldest": true,
+	"disconnect":  true,
+	"spill":       true,
+}
+
+func defaults() *Config {
+	return &Config{
+		Env:  "development",
+		Port: 8080,
+		STOMP: STOMP{
+			AckMode:         "client-individual",
+			HeartBeatSend:   10 * time.Second,
+			HeartBeatRecv:   10 * time.Second,
+			StartupPolicy:   PolicyDegrade,
+			MaxRedeliveries: 5,
+			ReceiptTimeout:  5 * time.Second,
+		},
+		Cluster: Cluster{
+			Prefix: "/topic/ws-chat.",
+		},
+		Offline: Offline{
+			Enabled:    true,
+			MaxPerUser: 100,
+			TTL:        24 * time.Hour,
+		},
+		Resume: Resume{
+			Buffer: 256,
+			TTL:    24 * time.Hour,
+		},
+		SlowConsumer: SlowConsumer{
+			WS:    "disconnect",
+			STOMP: "disconnect",
+		},
+		Auth: Auth{
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 30 * 24 * time.Hour,
+			Strict:     true,
+			Gateway: Gateway{
+				MaxSkew: time.Minute,
+			},
+			JWT: JWT{
+				Algorithms:   []string{"RS256", "ES256", "EdDSA"},
+				JWKSCacheTTL: 10 * time.Minute,
+			},
+		},
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// Load reads CONFIG_FILE (or ./config.yaml when present), applies
+// environment overrides and validates the result.
+func Load() (*Config, error) {
+	cfg := defaults()
+
+	path := os.Getenv("CONFIG_FILE")
+	for _, name := range defaultConfigFiles {
+		if path != "" {
+			break
+		}
+		if _, err := os.Stat(name); err == nil {
+			path = name
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+		if err := decodeFile(path, data, cfg); err != nil {
+			return nil, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Auth.Secrets) == 0 && !cfg.Production() {
+		// Tokens then only live as long as the process.
+		log.Println("No JWT secret configured: using a random one")
+		cfg.Auth.Secrets = []string{randomSecret()}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeFile reads a YAML file, or a TOML one by its extension. TOML is
+// re-encoded as YAML so both honor the same keys.
+func decodeFile(path string, data []byte, cfg *Config) error {
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		var tree map[string]interface{}
+		if err := toml.Unmarshal(data, &tree); err != nil {
+			return err
+		}
+		var err error
+		if data, err = yaml.Marshal(tree); err != nil {
+			return err
+		}
+	}
+	return yaml.Unmarshal(data, cfg)
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Production reports whether the service runs in production.
+func (c *Config) Production() bool {
+	return c.Env == EnvProduction || c.Env == "prod"
+}
+
+func (c *Config) applyEnv() error {
+	setString(&c.Env, "APP_ENV")
+	if err := setInt(&c.Port, "PORT"); err != nil {
+		return err
+	}
+
+	s := &c.STOMP
+	setString(&s.Broker, "STOMP_BROKER")
+	setString(&s.Login, "STOMP_LOGIN")
+	setString(&s.Passcode, "STOMP_PASSCODE")
+	setString(&s.VHost, "STOMP_VHOST")
+	setList(&s.Destinations, "STOMP_DESTINATIONS")
+	setString(&s.AckMode, "STOMP_ACK_MODE")
+	setString(&s.PublishDestination, "STOMP_PUBLISH_DESTINATION")
+	setMap(&s.PublishRoutes, "STOMP_PUBLISH_ROUTES")
+	setList(&s.PublishAllowed, "STOMP_PUBLISH_ALLOWED")
+	if err := setBool(&s.PublishReceipts, "STOMP_PUBLISH_RECEIPTS"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.ReceiptTimeout, "STOMP_RECEIPT_TIMEOUT"); err != nil {
+		return err
+	}
+	setString(&s.StartupPolicy, "STOMP_STARTUP_POLICY")
+	setString(&s.DeadLetter, "STOMP_DEAD_LETTER")
+	if err := setInt(&s.MaxRedeliveries, "STOMP_MAX_REDELIVERIES"); err != nil {
+		return err
+	}
+	if err := setBool(&s.RewritesMessageIDs, "STOMP_REWRITES_MESSAGE_IDS"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.HeartBeatSend, "STOMP_HEARTBEAT_SEND"); err != nil {
+		return err
+	}
+	if err := setDuration(&s.HeartBeatRecv, "STOMP_HEARTBEAT_RECV"); err != nil {
+		return err
+	}
+
+	if err := setBool(&c.Cluster.Enabled, "CLUSTER_ENABLED"); err != nil {
+		return err
+	}
+	setString(&c.Cluster.NodeID, "CLUSTER_NODE_ID")
+	setString(&c.Cluster.Prefix, "CLUSTER_PREFIX")
+
+	if err := setBool(&c.Offline.Enabled, "OFFLINE_ENABLED"); err != nil {
+		return err
+	}
+	if err := setInt(&c.Offline.MaxPerUser, "OFFLINE_MAX_PER_USER"); err != nil {
+		return err
+	}
+	if err := setDuration(&c.Offline.TTL, "OFFLINE_TTL"); err != nil {
+		return err
+	}
+
+	if err := setInt(&c.Resume.Buffer, "RESUME_BUFFER"); err != nil {
+		return err
+	}
+	if err := setDuration(&c.Resume.TTL, "RESUME_TTL"); err != nil {
+		return err
+	}
+
+	setString(&c.SlowConsumer.WS, "SLOW_CONSUMER_WS")
+	setString(&c.SlowConsumer.STOMP, "SLOW_CONSUMER_STOMP")
+
+	setList(&c.Auth.Secrets, "JWT_SECRETS")
+	if err := setDuration(&c.Auth.AccessTTL, "ACCESS_TOKEN_TTL"); err != nil {
+		return err
+	}
+	if err := setDuration(&c.Auth.RefreshTTL, "REFRESH_TOKEN_TTL"); err != nil {
+		return err
+	}
+	if err := setBool(&c.Auth.Strict, "AUTH_STRICT"); err != nil {
+		return err
+	}
+	setList(&c.Auth.Admins, "ADMIN_USER_IDS")
+	g := &c.Auth.Gateway
+	setList(&g.TrustedProxies, "GATEWAY_TRUSTED_PROXIES")
+	setString(&g.Secret, "GATEWAY_SECRET")
+	if err := setDuration(&g.MaxSkew, "GATEWAY_MAX_SKEW"); err != nil {
+		return err
+	}
+	j := &c.Auth.JWT
+	setList(&j.Algorithms, "JWT_ALGORITHMS")
+	setString(&j.Issuer, "JWT_ISSUER")
+	setString(&j.Audience, "JWT_AUDIENCE")
+	setList(&j.PublicKeys, "JWT_PUBLIC_KEYS")
+	setString(&j.JWKSFile, "JWT_JWKS_FILE")
+	setString(&j.JWKSURL, "JWT_JWKS_URL")
+	if err := setDuration(&j.JWKSCacheTTL, "JWT_JWKS_CACHE_TTL"); err != nil {
+		return err
+	}
+
+	return setDuration(&c.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+}
+
+// Validate rejects settings the service cannot run with.
+func (c *Config) Validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port: %d is not a TCP port", c.Port)
+	}
+	if err := c.validateSecrets(); err != nil {
+		return err
+	}
+
+	s := c.STOMP
+	if !validAckMode(s.AckMode) {
+		return fmt.Errorf("stomp.ack_mode: unknown mode %q", s.AckMode)
+	}
+	switch s.StartupPolicy {
+	case PolicyFail, PolicyDegrade:
+	default:
+		return fmt.Errorf("stomp.startup_policy: unknown policy %q", s.StartupPolicy)
+	}
+	if s.HeartBeatSend < 0 || s.HeartBeatRecv < 0 {
+		return errors.New("stomp heart-beats must not be negative")
+	}
+	if s.ReceiptTimeout <= 0 {
+		return errors.New("stomp.receipt_timeout must be positive")
+	}
+	for event, dest := range s.PublishRoutes {
+		if dest == "" {
+			return fmt.Errorf("stomp.publish_routes %s: destination is required", event)
+		}
+	}
+	if s.MaxRedeliveries < 1 {
+		return errors.New("stomp.max_redeliveries must be at least 1")
+	}
+	subs := s.AllSubscriptions()
+	if s.Broker != "" && len(subs) == 0 {
+		return errors.New("stomp.destinations: at least one destination is required when a broker is set")
+	}
+	for _, sub := range subs {
+		if sub.Destination == "" {
+			return errors.New("stomp.subscriptions: destination is required")
+		}
+		if !subscriptionKinds[sub.Kind] {
+			return fmt.Errorf("stomp.subscriptions %s: unknown kind %q", sub.Destination, sub.Kind)
+		}
+		if !validAckMode(sub.AckMode) {
+			return fmt.Errorf("stomp.subscriptions %s: unknown ack mode %q", sub.Destination, sub.AckMode)
+		}
+	}
+	if c.Cluster.Enabled && s.Broker == "" {
+		return errors.New("cluster.enabled requires stomp.broker")
+	}
+	if c.Cluster.Enabled {
+		// Publishing there would forge traffic between instances.
+		dests := append([]string{s.PublishDestination}, s.PublishAllowed...)
+		for _, d := range s.PublishRoutes {
+			dests = append(dests, d)
+		}
+		for _, d := range dests {
+			if d != "" && strings.HasPrefix(d, c.Cluster.Prefix) {
+				return fmt.Errorf("stomp publish destination %s is inside cluster.prefix", d)
+			}
+		}
+	}
+	if c.Offline.Enabled && (c.Offline.MaxPerUser < 1 || c.Offline.TTL <= 0) {
+		return errors.New("offline.max_per_user and offline.ttl must be positive")
+	}
+	if c.Resume.Buffer < 1 || c.Resume.TTL <= 0 {
+		return errors.New("resume.buffer and resume.ttl must be positive")
+	}
+	if !slowConsumerPolicies[c.SlowConsumer.WS] {
+		return fmt.Errorf("slow_consumer.ws: unknown policy %q", c.SlowConsumer.WS)
+	}
+	if !slowConsumerPolicies[c.SlowConsumer.STOMP] {
+		return fmt.Errorf("slow_consumer.stomp: unknown policy %q", c.SlowConsumer.STOMP)
+	}
+	if c.Auth.AccessTTL <= 0 || c.Auth.RefreshTTL < c.Auth.AccessTTL {
+		return errors.New("auth.access_ttl must be positive and auth.refresh_ttl at least as long")
+	}
+	for _, cidr := range c.Auth.Gateway.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("auth.gateway.trusted_proxies: %w", err)
+		}
+	}
+	if c.Auth.Gateway.Secret != "" && c.Auth.Gateway.MaxSkew <= 0 {
+		return errors.New("auth.gateway.max_skew must be positive")
+	}
+	if j := c.Auth.JWT; j.Enabled() {
+		if len(j.Algorithms) == 0 {
+			return errors.New("auth.jwt.algorithms: at least one algorithm is required")
+		}
+		for _, alg := range j.Algorithms {
+			if !jwtAlgorithms[alg] {
+				return fmt.Errorf("auth.jwt.algorithms: unsupported algorithm %q", alg)
+			}
+		}
+		if j.JWKSURL != "" && j.JWKSCacheTTL <= 0 {
+			return errors.New("auth.jwt.jwks_cache_ttl must be positive")
+		}
+	}
+	if c.ShutdownTimeout <= 0 {
+		return errors.New("shutdown_timeout must be positive")
+	}
+	return nil
+}
+
+func validAckMode(mode string) bool {
+	return mode == "auto" || mode == "client" || mode == "client-individual"
+}
+
+func setString(dst *string, key string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = strings.TrimSpace(v)
+	}
+}
+
+func setList(dst *[]string, key string) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	*dst = out
+}
+
+// setMap parses "key=value,key=value".
+func setMap(dst *map[string]string, key string) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return
+	}
+	out := make(map[string]string)
+	for _, item := range strings.Split(v, ",") {
+		k, val, _ := strings.Cut(item, "=")
+		if k = strings.TrimSpace(k); k != "" {
+			out[k] = strings.TrimSpace(val)
+		}
+	}
+	*dst = out
+}
+
+func setDuration(dst *time.Duration, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = d
+	return nil
+}
+
+func setInt(dst *int, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+func setBool(dst *bool, key string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = b
+	return nil
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// minSecretLen is the shortest HMAC secret accepted in production: 256 bits.
+const minSecretLen = 32
+
+// weakSecrets are secrets that shipped in code or docs at some point.
+var weakSecrets = map[string]bool{
+	"IAMSOMEONE": true,
+	"secret":     true,
+	"changeme":   true,
+	"password":   true,
+}
+
+const redacted = "[REDACTED]"
+
+// validateSecrets refuses to run production with a missing or guessable
+// secret, or with anonymous access.
+func (c *Config) validateSecrets() error {
+	if !c.Production() {
+		return nil
+	}
+	if len(c.Auth.Secrets) == 0 {
+		return errors.New("auth.secrets: a JWT secret is required in production")
+	}
+	for i, s := range c.Auth.Secrets {
+		if err := checkSecret(s); err != nil {
+			return fmt.Errorf("auth.secrets[%d]: %w", i, err)
+		}
+	}
+	if g := c.Auth.Gateway.Secret; g != "" {
+		if err := checkSecret(g); err != nil {
+			return fmt.Errorf("auth.gateway.secret: %w", err)
+		}
+	}
+	if !c.Auth.Strict {
+		return errors.New("auth.strict cannot be turned off in production")
+	}
+	return nil
+}
+
+func checkSecret(s string) error {
+	if weakSecrets[s] {
+		return errors.New("well-known secret")
+	}
+	if len(s) < minSecretLen {
+		return fmt.Errorf("shorter than %d bytes", minSecretLen)
+	}
+	return nil
+}
+
+// Redacted returns a copy of c with every secret replaced.
+func (c *Config) Redacted() *Config {
+	r := *c
+	if r.STOMP.Passcode != "" {
+		r.STOMP.Passcode = redacted
+	}
+	r.Auth.Secrets = make([]string, len(c.Auth.Secrets))
+	for i := range r.Auth.Secrets {
+		r.Auth.Secrets[i] = redacted
+	}
+	if r.Auth.Gateway.Secret != "" {
+		r.Auth.Gateway.Secret = redacted
+	}
+	r.Auth.JWT.JWKSURL = redactURL(r.Auth.JWT.JWKSURL)
+	return &r
+}
+
+// redactURL hides a password embedded in a URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	return u.String()
+}
+
+// String renders the effective configuration as YAML, secrets redacted.
+func (c *Config) String() string {
+	data, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return "config: " + err.Error()
+	}
+	return strings.TrimRight(string(data), "\n")
+}
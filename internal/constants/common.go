@@ -2,8 +2,23 @@ package constants
 
 import "github.com/gofrs/uuid"
 
-const JwtSecret = "IAMSOMEONE"
+// Token uses, carried in the token_use claim of tokens issued by /signin.
+const (
+	TokenAccess  = "access"
+	TokenRefresh = "refresh"
+)
 
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Subject string    `json:"sub,omitempty"` // identity provider tokens name the user here
+
+	ID        string `json:"jti,omitempty"`
+	Use       string `json:"token_use,omitempty"` // TokenAccess or TokenRefresh
+	SessionID string `json:"sid,omitempty"`       // shared by the tokens of one sign-in
+	ExpiresAt int64  `json:"exp,omitempty"`
+
+	Roles []string `json:"roles,omitempty"` // "admin" opens the admin routes
 }
+
+// RoleAdmin is the role of tokens allowed on the admin routes.
+const RoleAdmin = "admin"
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"errors"
+	"go-gin-example/internal/hub"
+	"go-gin-example/internal/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type disconnectRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ConnectionsHandler godoc
+// @Summary      List connections
+// @Description  Lists the sockets on this instance with their device metadata, optionally for one user
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        user_id  query  string  false  "Only this user's sockets"
+// @Success      200  {object}  map[string][]hub.ConnectionInfo  "connections"
+// @Failure      403  {object}  map[string]string  "not an admin"
+// @Router       /admin/connections [get]
+func (hd *Handler) ConnectionsHandler(c *gin.Context) {
+	conns := hd.hub.Connections(c.Query("user_id"))
+	if conns == nil {
+		conns = []hub.ConnectionInfo{}
+	}
+	c.JSON(http.StatusOK, gin.H{"node": hd.hub.NodeID(), "connections": conns})
+}
+
+// ConnectionHandler godoc
+// @Summary      Get a connection
+// @Description  Returns one socket on this instance with its device metadata
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Param        connection_id  path  string  true  "Connection ID"
+// @Success      200  {object}  hub.ConnectionInfo
+// @Failure      404  {object}  map[string]string  "error"
+// @Failure      403  {object}  map[string]string  "not an admin"
+// @Router       /admin/connections/{connection_id} [get]
+func (hd *Handler) ConnectionHandler(c *gin.Context) {
+	info, ok := hd.hub.Connection(c.Param("connection_id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": hub.ErrUnknownConnection.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// ConnectionMessageHandler godoc
+// @Summary      Send a message to one connection
+// @Description  Delivers a message from the caller to a single device of a user. It is not sequenced, so it is neither replayed nor seen by the user's other devices.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        connection_id  path  string          true  "Connection ID"
+// @Param        body           body  models.Message  true  "Message; the recipient is the connection's user"
+// @Success      200  {object}  map[string]interface{}  "message, delivery"
+// @Failure      400  {object}  map[string]string       "error"
+// @Failure      404  {object}  map[string]string       "error"
+// @Failure      503  {object}  map[string]string       "error"
+// @Failure      403  {object}  map[string]string  "not an admin"
+// @Router       /admin/connections/{connection_id}/messages [post]
+func (hd *Handler) ConnectionMessageHandler(c *gin.Context) {
+	var msg models.Message
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h := hd.hub
+	info, ok := h.Connection(c.Param("connection_id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": hub.ErrUnknownConnection.Error()})
+		return
+	}
+	hub.Stamp(c.MustGet("user_id").(string), &msg)
+	msg.RecipientID, msg.GroupID = info.UserID, ""
+	if err := h.Validate(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.DeliverToConnection(info.ID, &msg)
+	switch {
+	case errors.Is(err, hub.ErrUnknownConnection):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil && !errors.Is(err, hub.ErrDeliveryIncomplete):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg, "delivery": report})
+}
+
+// DisconnectHandler godoc
+// @Summary      Disconnect a connection
+// @Description  Closes one socket with code 4009 after flushing what it has buffered. The client may reconnect.
+// @Tags         admin
+// @Accept       json
+// @Security     BearerAuth
+// @Param        connection_id  path  string             true   "Connection ID"
+// @Param        body           body  disconnectRequest  false  "Close reason"
+// @Success      204
+// @Failure      404  {object}  map[string]string  "error"
+// @Failure      403  {object}  map[string]string  "not an admin"
+// @Router       /admin/connections/{connection_id} [delete]
+func (hd *Handler) DisconnectHandler(c *gin.Context) {
+	var req disconnectRequest
+	_ = c.ShouldBindJSON(&req) // the body is optional
+	if req.Reason == "" {
+		req.Reason = "disconnected by an administrator"
+	}
+	if err := hd.hub.Disconnect(c.Param("connection_id"), req.Reason); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
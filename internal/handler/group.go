@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type groupMembersRequest struct {
+	UserIDs []string `json:"user_ids" binding:"required,min=1"`
+}
+
+type createGroupRequest struct {
+	UserIDs []string `json:"user_ids"` // users to invite
+}
+
+// CreateGroupHandler godoc
+// @Summary      Create a group
+// @Description  Creates a group with a server-generated ID. The caller owns it and is its first member; the users in the body are invited.
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Param        body  body  createGroupRequest  false  "Users to invite"
+// @Success      201  {object}  map[string]interface{}  "group_id, owner, members, invited"
+// @Failure      400  {object}  map[string]string       "error"
+// @Router       /groups [post]
+func (hd *Handler) CreateGroupHandler(c *gin.Context) {
+	var req createGroupRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	userID := c.GetString("user_id")
+	h := hd.hub
+	groupID, invited := h.CreateGroup(userID, req.UserIDs...)
+
+	c.JSON(http.StatusCreated, gin.H{"group_id": groupID, "owner": userID, "members": h.GroupMembers(groupID), "invited": invited})
+}
+
+// JoinGroupHandler godoc
+// @Summary      Add members to a group
+// @Description  Members invite other users; an invited user joins by adding themselves. Nobody is added without their consent.
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Param        group_id  path  string               true  "Group ID"
+// @Param        body      body  groupMembersRequest  true  "Members to invite, or the caller to join"
+// @Success      200  {object}  map[string]interface{}  "group_id, members, invited"
+// @Failure      400  {object}  map[string]string       "error"
+// @Failure      403  {object}  map[string]string       "error"
+// @Router       /groups/{group_id}/members [post]
+func (hd *Handler) JoinGroupHandler(c *gin.Context) {
+	var req groupMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupID := c.Param("group_id")
+	h := hd.hub
+	_, invited, err := h.AddGroupMembers(groupID, c.GetString("user_id"), req.UserIDs)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"group_id": groupID, "members": h.GroupMembers(groupID), "invited": invited})
+}
+
+// LeaveGroupHandler godoc
+// @Summary      Remove members from a group
+// @Description  Members may remove themselves; the group owner may remove anyone
+// @Tags         groups
+// @Accept       json
+// @Produce      json
+// @Param        group_id  path  string               true  "Group ID"
+// @Param        body      body  groupMembersRequest  true  "Members to remove"
+// @Success      200  {object}  map[string]interface{}  "group_id, members"
+// @Failure      400  {object}  map[string]string       "error"
+// @Failure      403  {object}  map[string]string       "error"
+// @Router       /groups/{group_id}/members [delete]
+func (hd *Handler) LeaveGroupHandler(c *gin.Context) {
+	var req groupMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupID := c.Param("group_id")
+	h := hd.hub
+	if err := h.RemoveGroupMembers(groupID, c.GetString("user_id"), req.UserIDs); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"group_id": groupID, "members": h.GroupMembers(groupID)})
+}
+
+// GroupMembersHandler godoc
+// @Summary      List group members
+// @Tags         groups
+// @Produce      json
+// @Param        group_id  path  string  true  "Group ID"
+// @Success      200  {object}  map[string]interface{}  "group_id, members"
+// @Failure      403  {object}  map[string]string       "error"
+// @Router       /groups/{group_id}/members [get]
+func (hd *Handler) GroupMembersHandler(c *gin.Context) {
+	groupID := c.Param("group_id")
+	if !hd.hub.IsGroupMember(groupID, c.GetString("user_id")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this group"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"group_id": groupID, "members": hd.hub.GroupMembers(groupID)})
+}
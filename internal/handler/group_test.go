@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-gin-example/internal/hub"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestGroupMembershipNeedsAMember(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := hub.New(hub.Options{})
+	h.Start(t.Context())
+	defer h.Stop(t.Context())
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", c.GetHeader("X-Test-User")) })
+	hd := New(h)
+	r.POST("/groups", hd.CreateGroupHandler)
+	r.GET("/groups/:group_id/members", hd.GroupMembersHandler)
+	r.POST("/groups/:group_id/members", hd.JoinGroupHandler)
+	r.DELETE("/groups/:group_id/members", hd.LeaveGroupHandler)
+	r.GET("/ws", hd.WsHandler)
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-User", user)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/groups", "alice", `{"user_ids":["bob"]}`)
+	var created struct {
+		GroupID string   `json:"group_id"`
+		Owner   string   `json:"owner"`
+		Invited []string `json:"invited"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated || created.GroupID == "" || created.Owner != "alice" || len(created.Invited) != 1 {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body)
+	}
+	members := "/groups/" + created.GroupID + "/members"
+
+	steps := []struct {
+		name         string
+		method, path string
+		user, body   string
+		want         int
+	}{
+		{"claim an unknown group", http.MethodPost, "/groups/g1/members", "mallory", `{"user_ids":["mallory"]}`, http.StatusForbidden},
+		{"outsider joins", http.MethodPost, members, "mallory", `{"user_ids":["mallory"]}`, http.StatusForbidden},
+		{"outsider invites", http.MethodPost, members, "mallory", `{"user_ids":["bob"]}`, http.StatusForbidden},
+		{"outsider lists", http.MethodGet, members, "mallory", ``, http.StatusForbidden},
+		{"outsider removes", http.MethodDelete, members, "mallory", `{"user_ids":["alice"]}`, http.StatusForbidden},
+		{"invited user joins", http.MethodPost, members, "bob", `{"user_ids":["bob"]}`, http.StatusOK},
+		{"member invites", http.MethodPost, members, "bob", `{"user_ids":["carol","dave"]}`, http.StatusOK},
+		{"member removes another", http.MethodDelete, members, "bob", `{"user_ids":["alice"]}`, http.StatusForbidden},
+		{"invited user joins", http.MethodPost, members, "carol", `{"user_ids":["carol"]}`, http.StatusOK},
+		{"member leaves", http.MethodDelete, members, "carol", `{"user_ids":["carol"]}`, http.StatusOK},
+		{"invited user joins", http.MethodPost, members, "dave", `{"user_ids":["dave"]}`, http.StatusOK},
+		{"owner removes a member", http.MethodDelete, members, "alice", `{"user_ids":["dave"]}`, http.StatusOK},
+		{"member lists", http.MethodGet, members, "bob", ``, http.StatusOK},
+	}
+	for _, s := range steps {
+		if got := do(s.method, s.path, s.user, s.body).Code; got != s.want {
+			t.Fatalf("%s: status %d, want %d", s.name, got, s.want)
+		}
+	}
+	if got := h.GroupMembers(created.GroupID); len(got) != 2 {
+		t.Fatalf("members = %v, want alice and bob", got)
+	}
+	if h.IsGroupMember("g1", "mallory") {
+		t.Fatal("outsider claimed an unknown group")
+	}
+
+	// Nor can an outsider watch the group's room.
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?room_id=" + created.GroupID
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Test-User": {"mallory"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("outsider upgrade into room: %v (%v)", resp, err)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Test-User": {"bob"}})
+	if err != nil {
+		t.Fatalf("member upgrade into room: %v", err)
+	}
+	ws.Close()
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"go-gin-example/internal/hub"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+)
+
+// Handler serves the chat endpoints against one hub.
+type Handler struct {
+	hub *hub.Hub
+}
+
+func New(h *hub.Hub) *Handler {
+	return &Handler{hub: h}
+}
+
+// drainRetryAfter is the Retry-After, in seconds, of upgrades refused while
+// the hub shuts down.
+const drainRetryAfter = "5"
+
+// refuseWhileDraining answers 503 once the hub is shutting down so the
+// client reconnects to another instance.
+func (hd *Handler) refuseWhileDraining(c *gin.Context) bool {
+	if !hd.hub.Draining() {
+		return false
+	}
+	c.Header("Retry-After", drainRetryAfter)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server shutting down"})
+	return true
+}
+
+// roomFor returns the room_id an upgrade asks for, answering 403 when the
+// user is not a member of that group.
+func (hd *Handler) roomFor(c *gin.Context, userID string) (string, bool) {
+	roomID := c.Query("room_id") // empty for personal chats
+	if roomID != "" && !hd.hub.IsGroupMember(roomID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": hub.ErrNotGroupMember.Error()})
+		return "", false
+	}
+	return roomID, true
+}
+
+// newClientID names a socket uniquely across instances.
+func newClientID() string {
+	id, _ := uuid.NewV4()
+	return id.String()
+}
+
+// deviceInfo captures the device behind an upgrade. Browsers cannot set
+// headers on a WebSocket, so platform and app version may come as query
+// parameters instead.
+func deviceInfo(c *gin.Context) hub.DeviceInfo {
+	return hub.DeviceInfo{
+		UserAgent:   c.Request.UserAgent(),
+		Platform:    headerOrQuery(c, "X-Client-Platform", "platform"),
+		AppVersion:  headerOrQuery(c, "X-App-Version", "app_version"),
+		IP:          c.ClientIP(),
+		ConnectedAt: time.Now().UTC(),
+	}
+}
+
+func headerOrQuery(c *gin.Context, header, query string) string {
+	if v := c.GetHeader(header); v != "" {
+		return v
+	}
+	return c.Query(query)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"go-gin-example/internal/hub"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler godoc
+// @Summary      Service health
+// @Description  Reports broker connection state. Always 200 so a broker outage degrades the pod instead of restarting it
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}  "status, broker"
+// @Router       /health [get]
+func (hd *Handler) HealthHandler(c *gin.Context) {
+	h := hd.hub
+	broker := h.BrokerHealth()
+
+	status := "ok"
+	if broker.State != hub.BrokerConnected && broker.State != hub.BrokerDisabled {
+		status = "degraded"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": status,
+		"broker": broker,
+		"node":   h.NodeID(),
+	})
+}
+
+// SlowConsumersHandler godoc
+// @Summary      Slow-consumer metrics
+// @Description  Per-user counts of frames dropped, evicted or spilled and of sockets disconnected because their send buffer was full, with the endpoint policies in force
+// @Tags         health
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  map[string]interface{}  "policies, users, slowest"
+// @Failure      403  {object}  map[string]string       "error"
+// @Router       /admin/metrics/slow-consumers [get]
+func (hd *Handler) SlowConsumersHandler(c *gin.Context) {
+	h := hd.hub
+	c.JSON(http.StatusOK, gin.H{
+		"policies": gin.H{
+			hub.EndpointWS:    h.SlowConsumerPolicy(hub.EndpointWS),
+			hub.EndpointSTOMP: h.SlowConsumerPolicy(hub.EndpointSTOMP),
+		},
+		"users":   h.SlowConsumerStats(),
+		"slowest": h.SlowestUsers(10),
+	})
+}
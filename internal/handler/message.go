@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"go-gin-example/internal/hub"
+	"go-gin-example/internal/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deliveryInfo extends the hub report with the recipients' presence.
+type deliveryInfo struct {
+	hub.DeliveryReport
+	RecipientOnline bool `json:"recipient_online"`         // direct messages
+	MembersOnline   int  `json:"members_online,omitempty"` // group messages
+}
+
+// SendMessageHandler godoc
+// @Summary      Send a message to a user or group
+// @Description  Delivers a message from the caller to the recipient's or group's live sockets on every instance. The sender, ID and timestamp are assigned by the server. Typing and read events are throttled per sender like on a socket. The message is not mirrored to the broker; use /stomp/publish for that.
+// @Tags         messages
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body  models.Message  true  "Message with recipient_id or group_id"
+// @Success      200  {object}  map[string]interface{}  "message, delivery"
+// @Failure      400  {object}  map[string]string       "error"
+// @Failure      429  {object}  map[string]string       "error"
+// @Failure      503  {object}  map[string]string       "error"
+// @Router       /messages [post]
+func (hd *Handler) SendMessageHandler(c *gin.Context) {
+	var msg models.Message
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h := hd.hub
+	hub.Stamp(c.MustGet("user_id").(string), &msg)
+	if err := h.Validate(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg.Ephemeral() {
+		if err := h.ThrottleEphemeral(&msg); err != nil {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	report, err := h.Deliver(&msg)
+	if err != nil && !errors.Is(err, hub.ErrDeliveryIncomplete) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	info := deliveryInfo{DeliveryReport: report}
+	if msg.GroupID != "" {
+		for _, st := range h.Presence(h.GroupMembers(msg.GroupID)...) {
+			if st.Online && st.UserID != msg.SenderID {
+				info.MembersOnline++
+			}
+		}
+	} else {
+		info.RecipientOnline = h.Presence(msg.RecipientID)[0].Online
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": msg, "delivery": info})
+}
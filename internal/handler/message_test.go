@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gin-example/internal/hub"
+	"go-gin-example/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSendMessageUsesInjectedHub(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, other := hub.New(hub.Options{}), hub.New(hub.Options{})
+	bob := &hub.Client{ID: "b1", UserID: "bob", Send: make(chan []byte, 4)}
+	h.Register(bob)
+	h.Start(t.Context())
+	defer h.Stop(t.Context())
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", "alice") })
+	r.POST("/messages", New(h).SendMessageHandler)
+
+	deadline := time.Now().Add(time.Second)
+	for len(h.GetClientsByUser("bob")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("bob was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient_id":"bob","content":"hi"}`))
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rr.Code, rr.Body)
+	}
+
+	var msg models.Message
+	if err := json.Unmarshal(<-bob.Send, &msg); err != nil || msg.SenderID != "alice" || msg.Content != "hi" {
+		t.Fatalf("bob got %+v (%v)", msg, err)
+	}
+	if got := other.Presence("bob")[0]; got.Online {
+		t.Fatal("message leaked into another hub")
+	}
+	// Typing events are throttled like on a socket.
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(`{"recipient_id":"bob","event_type":"typing.start"}`)))
+		if rr.Code != want {
+			t.Fatalf("typing.start #%d: status = %d, want %d", i+1, rr.Code, want)
+		}
+	}
+}
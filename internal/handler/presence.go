@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxPresenceQuery = 500
+
+// PresenceHandler godoc
+// @Summary      Query user presence
+// @Description  Reports whether each user has a live socket and when they were last seen
+// @Tags         presence
+// @Produce      json
+// @Param        user_ids  query  string  true  "Comma-separated user IDs"
+// @Success      200  {object}  map[string][]models.PresenceStatus  "presence"
+// @Failure      400  {object}  map[string]string                   "error"
+// @Router       /presence [get]
+func (hd *Handler) PresenceHandler(c *gin.Context) {
+	var userIDs []string
+	for _, v := range c.QueryArray("user_ids") {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				userIDs = append(userIDs, id)
+			}
+		}
+	}
+	if len(userIDs) == 0 || len(userIDs) > maxPresenceQuery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_ids must list between 1 and 500 users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presence": hd.hub.Presence(userIDs...)})
+}
@@ -3,41 +3,81 @@ package handler
 import (
 	"encoding/json"
 	"go-gin-example/internal/hub"
+	"go-gin-example/internal/models"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+// AuthSubprotocol marks the access token in Sec-WebSocket-Protocol.
+// Browsers cannot set headers on a WebSocket, so they offer
+// ["access_token", "<jwt>"] and the upgrade selects "access_token".
+const AuthSubprotocol = "access_token"
+
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true }, // dev only
+	CheckOrigin:  func(r *http.Request) bool { return true }, // dev only
+	Subprotocols: []string{AuthSubprotocol},
 }
 
-// Handle WebSocket
-func WsHandler(c *gin.Context) {
+// WsHandler upgrades to a JSON WebSocket session for the authenticated
+// user. The client is greeted with a welcome carrying the resume epoch,
+// then receives replayed and live deliveries until it disconnects.
+func (hd *Handler) WsHandler(c *gin.Context) {
+	if hd.refuseWhileDraining(c) {
+		return
+	}
 
-	// Authenticated userId will be injected by gateway
-	ctxUserId := c.GetString("user_id")
+	// Authenticated userId is injected by the middleware
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	resume, err := hub.ParseCursor(c.Query("epoch"), c.Query("last_seq"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	roomID, ok := hd.roomFor(c, userID)
+	if !ok {
+		return
+	}
 
-	// 4. Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
-	defer conn.Close()
 
-	// 5. Tạo client
-	client := &hub.Client{Conn: conn, Send: make(chan []byte, 256)}
-	hub.Get().Register <- client
-
-	// Gửi welcome
-	welcome := map[string]string{
-		"type":    "welcome",
-		"message": "Connected as " + ctxUserId,
+	h := hd.hub
+	client := &hub.Client{
+		ID:         newClientID(),
+		UserID:     userID,
+		RoomID:     roomID,
+		Conn:       conn,
+		Send:       make(chan []byte, 256),
+		Resume:     resume,
+		Device:     deviceInfo(c),
+		TokenID:    c.GetString("token_id"),
+		SlowPolicy: h.SlowConsumerPolicy(hub.EndpointWS),
 	}
-	sendJSON(client, welcome)
+
+	// Queued before registering so it precedes any replayed event.
+	sendJSON(client, models.WelcomeMessage{
+		Type:    "welcome",
+		Message: "Connected as " + userID,
+		UserID:  userID,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Epoch:   h.ResumeCursor(userID).Epoch, // pass back with last_seq to resume
+
+		ConnectionID: client.ID,
+	})
+	h.Register(client)
+	go client.WritePump()
+	go client.ReadPump(h)
 }
 
 // Gửi JSON
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gin-example/internal/hub"
+	"go-gin-example/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestWsHandlerRunsASession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := hub.New(hub.Options{})
+	h.Start(t.Context())
+	defer h.Stop(t.Context())
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", "alice") })
+	r.GET("/ws", New(h).WsHandler)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?platform=web&app_version=1.4.2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var welcome models.WelcomeMessage
+	if err := ws.ReadJSON(&welcome); err != nil {
+		t.Fatal(err)
+	}
+	if welcome.Type != "welcome" || welcome.UserID != "alice" || welcome.Time == "" || welcome.Epoch != h.ResumeCursor("alice").Epoch {
+		t.Fatalf("welcome %+v", welcome)
+	}
+
+	waitOnline(t, h, "alice", true)
+	conn, ok := h.Connection(welcome.ConnectionID)
+	if !ok || conn.UserID != "alice" || conn.Device.Platform != "web" || conn.Device.AppVersion != "1.4.2" || conn.Device.UserAgent == "" || conn.Device.IP == "" {
+		t.Fatalf("connection %+v (%v)", conn, ok)
+	}
+	if _, err := h.Deliver(&models.Message{SenderID: "bob", RecipientID: "alice", Content: "hi", EventType: models.EventTypeSent}); err != nil {
+		t.Fatal(err)
+	}
+	var msg models.Message
+	if err := ws.ReadJSON(&msg); err != nil || msg.Content != "hi" || msg.Seq == 0 {
+		t.Fatalf("alice got %+v (%v)", msg, err)
+	}
+
+	ws.Close()
+	waitOnline(t, h, "alice", false)
+}
+
+func waitOnline(t *testing.T, h *hub.Hub, userID string, online bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for (len(h.GetClientsByUser(userID)) > 0) != online {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s online = %v, want %v", userID, !online, online)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
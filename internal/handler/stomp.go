@@ -1,43 +1,142 @@
 package handler
 
 import (
+	"errors"
 	"go-gin-example/internal/hub"
 	"go-gin-example/internal/models"
+	"go-gin-example/internal/stompws"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
-func StompHandler(c *gin.Context) {
-	userID := c.GetHeader("UserID")
+var stompUpgrader = websocket.Upgrader{
+	CheckOrigin:  func(r *http.Request) bool { return true }, // dev only
+	Subprotocols: append(append([]string{}, stompws.Subprotocols...), AuthSubprotocol),
+}
+
+// StompHandler upgrades to a WebSocket speaking STOMP 1.2. Clients
+// subscribe to /user/queue/messages or /topic/group.<id> and receive
+// hub deliveries as MESSAGE frames.
+func (hd *Handler) StompHandler(c *gin.Context) {
+	if hd.refuseWhileDraining(c) {
+		return
+	}
+	// Authenticated userId is injected by the middleware
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	resume, err := hub.ParseCursor(c.Query("epoch"), c.Query("last_seq"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	roomID, ok := hd.roomFor(c, userID)
+	if !ok {
+		return
+	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := stompUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("WS upgrade:", err)
 		return
 	}
 
+	h := hd.hub
 	client := &hub.Client{
-		ID:     time.Now().Format("150405.000000"),
-		UserID: userID,
-		RoomID: "", // TODO: empty for personal, need to support for group
-		Conn:   conn,
-		Send:   make(chan []byte, 256),
+		ID:         newClientID(),
+		UserID:     userID,
+		RoomID:     roomID,
+		Conn:       conn,
+		Send:       make(chan []byte, 256),
+		Resume:     resume,
+		Device:     deviceInfo(c),
+		TokenID:    c.GetString("token_id"),
+		SlowPolicy: h.SlowConsumerPolicy(hub.EndpointSTOMP),
 	}
 
-	h := hub.Get()
-	h.Register <- client
+	session := stompws.NewSession(h, client)
+	h.Register(client)
 	go client.WritePump()
-	go client.ReadPump(h)
+	go session.Serve()
 }
 
-func SendStompPrivateHandler(c *gin.Context) {
-	msg := models.Message{
-		RecipientID: "536080c8-3f5e-4471-b8ae-6ed2085f7649",
-		Content:     "hello world",
+// publishHeaders are the frame headers callers may set on a publish; others
+// are dropped so a caller cannot steer the broker or spoof gateway metadata.
+var publishHeaders = map[string]bool{
+	"priority":   true,
+	"persistent": true,
+	"expires":    true,
+}
+
+type publishRequest struct {
+	models.Message
+	Destination   string            `json:"destination"`
+	CorrelationID string            `json:"correlation_id"`
+	Receipt       bool              `json:"receipt"`
+	Headers       map[string]string `json:"headers"`
+}
+
+// PublishHandler godoc
+// @Summary      Publish an event to the STOMP broker
+// @Description  Stamps the message as sent by the caller and publishes it to the destination routed for its event type, or to an explicit configured destination. Only the priority, persistent and expires headers are passed on.
+// @Tags         stomp
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body  publishRequest  true  "Message plus optional destination, correlation_id, receipt and headers"
+// @Success      200  {object}  map[string]interface{}  "message, publish"
+// @Failure      400  {object}  map[string]string       "error"
+// @Failure      403  {object}  map[string]string       "error"
+// @Failure      502  {object}  map[string]string       "error"
+// @Failure      503  {object}  map[string]string       "error"
+// @Router       /stomp/publish [post]
+func (hd *Handler) PublishHandler(c *gin.Context) {
+	var req publishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h := hd.hub
+	msg := req.Message
+	hub.Stamp(c.MustGet("user_id").(string), &msg)
+	if err := h.Validate(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	headers := make(map[string]string)
+	for k, v := range req.Headers {
+		if publishHeaders[strings.ToLower(k)] {
+			headers[strings.ToLower(k)] = v
+		}
 	}
-	hub.Get().Broadcast <- &msg
-	c.JSON(http.StatusOK, msg)
+	res, err := h.Publish(&msg, hub.PublishOptions{
+		Destination:   req.Destination,
+		CorrelationID: req.CorrelationID,
+		Receipt:       req.Receipt,
+		Headers:       headers,
+	})
+	switch {
+	case errors.Is(err, hub.ErrNoPublishDestination), errors.Is(err, hub.ErrReservedHeader):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, hub.ErrDestinationForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, hub.ErrBrokerUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": msg, "publish": res})
 }
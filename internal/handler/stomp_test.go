@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-gin-example/internal/hub"
+	"go-gin-example/internal/stompws"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/gorilla/websocket"
+)
+
+func TestStompFinalFramesReachTheClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := hub.New(hub.Options{})
+	h.Start(t.Context())
+	defer h.Stop(t.Context())
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", "alice") })
+	r.GET("/stomp", New(h).StompHandler)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/stomp"
+
+	// exchange sends frames and returns the last frame the server wrote
+	// before closing the socket.
+	exchange := func(frames ...*frame.Frame) *frame.Frame {
+		t.Helper()
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		for _, f := range frames {
+			if err := ws.WriteMessage(websocket.TextMessage, stompws.Encode(f)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var last *frame.Frame
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return last
+			}
+			if f, err := stompws.Decode(data); err == nil && f != nil {
+				last = f
+			}
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		f := exchange(
+			frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"),
+			frame.New(frame.DISCONNECT, frame.Receipt, "bye"),
+		)
+		if f == nil || f.Command != frame.RECEIPT || f.Header.Get(frame.ReceiptId) != "bye" {
+			t.Fatalf("attempt %d: last frame %v, want RECEIPT bye", i, f)
+		}
+
+		f = exchange(frame.New(frame.SUBSCRIBE, frame.Id, "0", frame.Destination, stompws.UserQueue))
+		if f == nil || f.Command != frame.ERROR {
+			t.Fatalf("attempt %d: last frame %v, want ERROR", i, f)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// SignGatewayIdentity is the X-Gateway-Signature the gateway sends with an
+// identity header: hex HMAC-SHA256 of "<user id>\n<unix timestamp>".
+func SignGatewayIdentity(secret, userID, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userID + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
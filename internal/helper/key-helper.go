@@ -0,0 +1,402 @@
+package helper
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyProvider resolves the keys that may have signed a token, given the
+// token's kid header (possibly empty) and algorithm. Providers only return
+// keys of the algorithm's family, so an RSA key can never verify an HMAC
+// token and the other way round.
+type KeyProvider interface {
+	Keys(kid, alg string) ([]any, error)
+}
+
+var ErrNoKey = errors.New("no verification key")
+
+// namedKey is a public key with its JWKS kid, empty for PEM keys.
+type namedKey struct {
+	kid string
+	key any
+}
+
+// match returns the keys usable for a token. A kid selects the key with
+// that kid; keys without one (PEM) are candidates for every token.
+func match(keys []namedKey, kid, alg string) []any {
+	var out []any
+	for _, k := range keys {
+		if (k.kid == "" || k.kid == kid || kid == "") && fitsAlg(k.key, alg) {
+			out = append(out, k.key)
+		}
+	}
+	return out
+}
+
+func fitsAlg(key any, alg string) bool {
+	switch k := key.(type) {
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return k.Curve == elliptic.P256()
+		case "ES384":
+			return k.Curve == elliptic.P384()
+		case "ES512":
+			return k.Curve == elliptic.P521()
+		}
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+// HMACKey is a shared secret for HS256, HS384 and HS512 tokens.
+type HMACKey []byte
+
+func (k HMACKey) Keys(kid, alg string) ([]any, error) {
+	return match([]namedKey{{key: []byte(k)}}, kid, alg), nil
+}
+
+// ======================
+// PEM keys
+// ======================
+
+// StaticKeys is a fixed set of public keys.
+type StaticKeys struct {
+	keys []namedKey
+}
+
+func (s *StaticKeys) Keys(kid, alg string) ([]any, error) {
+	return match(s.keys, kid, alg), nil
+}
+
+// ParsePEMKeys reads every public key or certificate in PEM data.
+func ParsePEMKeys(data []byte) (*StaticKeys, error) {
+	s := &StaticKeys{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key any
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", block.Type, err)
+		}
+		s.keys = append(s.keys, namedKey{key: key})
+	}
+	if len(s.keys) == 0 {
+		return nil, errors.New("no public key in PEM data")
+	}
+	return s, nil
+}
+
+// LoadPEMKeys reads the public keys of PEM files.
+func LoadPEMKeys(paths ...string) (*StaticKeys, error) {
+	all := &StaticKeys{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s, err := ParsePEMKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		all.keys = append(all.keys, s.keys...)
+	}
+	return all, nil
+}
+
+// ======================
+// JWKS
+// ======================
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the signature keys of a JWK set. Keys of unknown types
+// or meant for encryption are skipped.
+func parseJWKS(data []byte) ([]namedKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+	var out []namedKey
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			out = append(out, namedKey{kid: k.Kid, key: key})
+		}
+	}
+	return out, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64Int(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64Int(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64Int(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64Int(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("bad base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWKSFile serves the keys of a local JWK set file. The file is read again
+// when a kid is unknown and the file changed, so keys can be rotated in
+// place.
+type JWKSFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    []namedKey
+}
+
+func LoadJWKSFile(path string) (*JWKSFile, error) {
+	f := &JWKSFile{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *JWKSFile) Keys(kid, alg string) ([]any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := match(f.keys, kid, alg)
+	if len(keys) > 0 || kid == "" {
+		return keys, nil
+	}
+	if st, err := os.Stat(f.path); err == nil && !st.ModTime().Equal(f.modTime) {
+		_ = f.reloadLocked() // a half-written file keeps the previous keys
+	}
+	return match(f.keys, kid, alg), nil
+}
+
+func (f *JWKSFile) reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reloadLocked()
+}
+
+func (f *JWKSFile) reloadLocked() error {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.keys, f.modTime = keys, st.ModTime()
+	return nil
+}
+
+// JWKSURL serves the keys of a remote JWK set. The set is cached for TTL
+// and fetched again early when a kid is unknown. Fetches are attempted at
+// most once per MinRefresh, so unknown kids or an outage cannot hammer the
+// identity provider; the last good set is served meanwhile. One fetch runs
+// at a time, without holding the lock; callers needing fresh keys wait for
+// it.
+type JWKSURL struct {
+	URL        string
+	TTL        time.Duration
+	MinRefresh time.Duration
+	Client     *http.Client
+
+	mu       sync.Mutex
+	fetched  time.Time // last successful fetch
+	tried    time.Time // last attempt
+	lastErr  error
+	keys     []namedKey
+	inflight chan struct{} // closed when the running fetch ends
+}
+
+// NewJWKSURL returns a provider for url whose set is cached for ttl.
+func NewJWKSURL(url string, ttl time.Duration) *JWKSURL {
+	return &JWKSURL{
+		URL:        url,
+		TTL:        ttl,
+		MinRefresh: time.Minute,
+		Client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (j *JWKSURL) Keys(kid, alg string) ([]any, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	stale := j.fetched.IsZero() || now.Sub(j.fetched) > j.TTL ||
+		(kid != "" && len(match(j.keys, kid, alg)) == 0) // maybe a rotated key
+	switch {
+	case stale && j.inflight != nil:
+		done := j.inflight
+		j.mu.Unlock()
+		<-done
+		j.mu.Lock()
+	case stale && (j.tried.IsZero() || now.Sub(j.tried) >= j.MinRefresh):
+		j.tried = now
+		done := make(chan struct{})
+		j.inflight = done
+		j.mu.Unlock()
+		keys, err := j.fetch()
+		j.mu.Lock()
+		if err == nil {
+			j.keys, j.fetched = keys, now
+		}
+		j.lastErr = err
+		j.inflight = nil
+		close(done)
+	}
+	if j.fetched.IsZero() {
+		return nil, j.lastErr
+	}
+	return match(j.keys, kid, alg), nil
+}
+
+// fetch downloads and parses the set. It touches no cached state, so it
+// runs without j.mu.
+func (j *JWKSURL) fetch() ([]namedKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+// KeyProviders combines providers, e.g. the HMAC secret of locally issued
+// tokens with the identity provider's JWKS.
+func KeyProviders(providers ...KeyProvider) KeyProvider {
+	return keyChain(providers)
+}
+
+type keyChain []KeyProvider
+
+func (c keyChain) Keys(kid, alg string) ([]any, error) {
+	var out []any
+	var firstErr error
+	for _, p := range c {
+		keys, err := p.Keys(kid, alg)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		out = append(out, keys...)
+	}
+	if len(out) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return out, nil
+}
@@ -0,0 +1,227 @@
+package helper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type testClaims struct {
+	Subject string `json:"sub"`
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key crypto.Signer, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func toJWK(kid string, pub crypto.PublicKey) map[string]string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k)}
+	}
+	panic("unsupported key")
+}
+
+func jwks(keys ...map[string]string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return data
+}
+
+func claims(sub string) jwt.MapClaims {
+	return jwt.MapClaims{"sub": sub, "iss": "https://idp.example", "aud": "ws-chat", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestJWKSURLCachesAndFollowsRotation(t *testing.T) {
+	k1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	k2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var set atomic.Value
+	set.Store(jwks(toJWK("k1", &k1.PublicKey)))
+	var fetches atomic.Int32
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(set.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	keys := NewJWKSURL(srv.URL, time.Hour)
+	keys.MinRefresh = 0
+	v := &JwtVerifier{Keys: keys, Algorithms: []string{"RS256"}, Issuer: "https://idp.example", Audience: "ws-chat"}
+
+	for i := 0; i < 3; i++ {
+		got, err := VerifyJwtClaim[testClaims](sign(t, jwt.SigningMethodRS256, k1, "k1", claims("alice")), v)
+		if err != nil || got.Subject != "alice" {
+			t.Fatalf("k1 token: %+v, %v", got, err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("fetched %d times, want the set cached", n)
+	}
+
+	// The provider rotates to k2; an unknown kid triggers a refresh.
+	set.Store(jwks(toJWK("k2", &k2.PublicKey)))
+	if _, err := VerifyJwtClaim[testClaims](sign(t, jwt.SigningMethodRS256, k2, "k2", claims("bob")), v); err != nil {
+		t.Fatalf("k2 token after rotation: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("fetched %d times, want one refresh", n)
+	}
+
+	// A failing refresh keeps serving the last good set.
+	down.Store(true)
+	if _, err := VerifyJwtClaim[testClaims](sign(t, jwt.SigningMethodRS256, k2, "k3", claims("bob")), v); err == nil {
+		t.Fatal("unknown kid accepted")
+	}
+	if _, err := VerifyJwtClaim[testClaims](sign(t, jwt.SigningMethodRS256, k2, "k2", claims("bob")), v); err != nil {
+		t.Fatalf("k2 token during outage: %v", err)
+	}
+}
+
+func TestJWKSURLFetchesOnceWithoutBlockingCachedKeys(t *testing.T) {
+	k1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		w.Write(jwks(toJWK("k1", &k1.PublicKey)))
+	}))
+	defer srv.Close()
+
+	keys := NewJWKSURL(srv.URL, time.Hour)
+	if got, err := keys.Keys("k1", "RS256"); err != nil || len(got) != 1 {
+		t.Fatalf("first fetch: %v, %v", got, err)
+	}
+
+	// Unknown kids start one refresh, which hangs; the others wait for it.
+	keys.mu.Lock()
+	keys.tried = time.Time{}
+	keys.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys.Keys("k9", "RS256")
+		}()
+	}
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cached := make(chan int)
+	go func() {
+		got, _ := keys.Keys("k1", "RS256")
+		cached <- len(got)
+	}()
+	select {
+	case n := <-cached:
+		if n != 1 {
+			t.Fatalf("cached key lookup returned %d keys", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cached key lookup blocked on the running fetch")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("fetched %d times, want 2", n)
+	}
+}
+
+func TestVerifierChecksAlgorithmIssuerAndAudience(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&ec.PublicKey)
+	keys, err := ParsePEMKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &JwtVerifier{Keys: keys, Algorithms: []string{"ES256"}, Issuer: "https://idp.example", Audience: "ws-chat"}
+
+	if _, err := VerifyJwtClaim[testClaims](sign(t, jwt.SigningMethodES256, ec, "", claims("alice")), v); err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	wrongIss := claims("alice")
+	wrongIss["iss"] = "https://evil.example"
+	wrongAud := claims("alice")
+	wrongAud["aud"] = "other-service"
+	for name, tok := range map[string]string{
+		"issuer":   sign(t, jwt.SigningMethodES256, ec, "", wrongIss),
+		"audience": sign(t, jwt.SigningMethodES256, ec, "", wrongAud),
+	} {
+		if _, err := VerifyJwtClaim[testClaims](tok, v); err == nil {
+			t.Errorf("wrong %s accepted", name)
+		}
+	}
+
+	v.Algorithms = []string{"RS256"}
+	if _, err := VerifyJwtClaim[testClaims](sign(t, jwt.SigningMethodES256, ec, "", claims("alice")), v); err == nil {
+		t.Fatal("disallowed algorithm accepted")
+	}
+
+	// An HS token must not verify against the public key bytes.
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims("mallory"))
+	forged, _ := hs.SignedString(der)
+	v.Algorithms = []string{"ES256", "HS256"}
+	if _, err := VerifyJwtClaim[testClaims](forged, v); err == nil {
+		t.Fatal("HMAC token verified with a public key")
+	}
+}
+
+func TestJWKSFileEdDSA(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks(toJWK("ed", pub)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadJWKSFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &JwtVerifier{Keys: KeyProviders(HMACKey("secret"), keys), Algorithms: []string{"EdDSA", "HS512"}}
+	if got, err := VerifyJwtClaim[testClaims](sign(t, jwt.SigningMethodEdDSA, priv, "ed", claims("alice")), v); err != nil || got.Subject != "alice" {
+		t.Fatalf("EdDSA token: %+v, %v", got, err)
+	}
+	if _, err := ExtractJwtClaim[testClaims](sign(t, jwt.SigningMethodEdDSA, priv, "ed", claims("alice")), "secret"); err == nil {
+		t.Fatal("HS512-only extraction accepted an EdDSA token")
+	}
+}
@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// RevocationStore records revoked token IDs (the jti claim) until the
+// tokens would have expired anyway. Implementations backed by a shared
+// store let every instance honor a sign-out.
+type RevocationStore interface {
+	Revoke(jti string, until time.Time) error
+	Revoked(jti string) (bool, error)
+}
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// MemoryRevocations keeps revocations in process memory. Entries are
+// dropped once their token expired.
+type MemoryRevocations struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+	swept time.Time
+}
+
+func NewMemoryRevocations() *MemoryRevocations {
+	return &MemoryRevocations{until: make(map[string]time.Time)}
+}
+
+func (m *MemoryRevocations) Revoke(jti string, until time.Time) error {
+	if jti == "" {
+		return errors.New("token has no jti")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.swept) > time.Minute {
+		for id, t := range m.until {
+			if now.After(t) {
+				delete(m.until, id)
+			}
+		}
+		m.swept = now
+	}
+	if until.After(m.until[jti]) {
+		m.until[jti] = until
+	}
+	return nil
+}
+
+func (m *MemoryRevocations) Revoked(jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until, ok := m.until[jti]
+	return ok && time.Now().Before(until), nil
+}
@@ -0,0 +1,116 @@
+package helper
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// A sign-in starts a session: the tokens issued for it share its sid. A
+// session has one live refresh token; refreshing swaps it for a new one,
+// so a refresh token presented twice was copied and the whole session is
+// revoked.
+
+var (
+	ErrUnknownSession = errors.New("session ended or unknown")
+	ErrRefreshReused  = errors.New("refresh token reused: session revoked")
+)
+
+// Session is the state of one sign-in.
+type Session struct {
+	UserID  uuid.UUID
+	Refresh string               // jti of the live refresh token
+	Access  map[string]time.Time // jti → expiry of the access tokens issued
+	Expires time.Time            // when the live refresh token expires
+}
+
+// SessionTokens describes a freshly signed token pair.
+type SessionTokens struct {
+	UserID        uuid.UUID
+	Access        string // jti of the access token
+	AccessExpires time.Time
+	Refresh       string // jti of the refresh token
+	Expires       time.Time
+}
+
+// SessionStore keeps sessions by sid. Rotate must check and swap the live
+// refresh token atomically; a stale one ends the session, which is
+// returned with ErrRefreshReused so its access tokens can be revoked.
+// Implementations backed by a shared store let any instance refresh or end
+// a session, and detect reuse across instances.
+type SessionStore interface {
+	Start(sid string, t SessionTokens) error
+	Rotate(sid, oldRefresh string, t SessionTokens) (*Session, error)
+	End(sid string) (*Session, error)
+}
+
+// MemorySessions keeps sessions in process memory. Sessions are dropped
+// once their refresh token expired.
+type MemorySessions struct {
+	mu    sync.Mutex
+	byID  map[string]*Session
+	swept time.Time
+}
+
+func NewMemorySessions() *MemorySessions {
+	return &MemorySessions{byID: make(map[string]*Session)}
+}
+
+func (m *MemorySessions) Start(sid string, t SessionTokens) error {
+	if sid == "" {
+		return errors.New("session has no id")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.swept) > time.Minute {
+		for id, sess := range m.byID {
+			if now.After(sess.Expires) {
+				delete(m.byID, id)
+			}
+		}
+		m.swept = now
+	}
+	sess := &Session{UserID: t.UserID, Access: make(map[string]time.Time)}
+	sess.add(t)
+	m.byID[sid] = sess
+	return nil
+}
+
+func (m *MemorySessions) Rotate(sid, oldRefresh string, t SessionTokens) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.byID[sid]
+	if sess == nil || sess.UserID != t.UserID || time.Now().After(sess.Expires) {
+		return nil, ErrUnknownSession
+	}
+	if sess.Refresh != oldRefresh {
+		delete(m.byID, sid)
+		return sess, ErrRefreshReused
+	}
+	sess.add(t)
+	return sess, nil
+}
+
+// End removes a session and returns it, nil when unknown.
+func (m *MemorySessions) End(sid string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.byID[sid]
+	delete(m.byID, sid)
+	return sess, nil
+}
+
+func (sess *Session) add(t SessionTokens) {
+	now := time.Now()
+	for jti, exp := range sess.Access {
+		if now.After(exp) {
+			delete(sess.Access, jti)
+		}
+	}
+	sess.Access[t.Access] = t.AccessExpires
+	sess.Refresh = t.Refresh
+	sess.Expires = t.Expires
+}
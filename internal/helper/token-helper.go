@@ -10,23 +10,64 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// JwtVerifier checks signatures and registered claims of incoming tokens.
+type JwtVerifier struct {
+	Keys       KeyProvider
+	Algorithms []string // accepted "alg" values, e.g. RS256, ES256, EdDSA, HS512
+	Issuer     string   // required "iss" when set
+	Audience   string   // required in "aud" when set
+	Leeway     time.Duration
+
+	Revocations RevocationStore // consulted on the jti claim when set
+}
+
+// ExtractJwtClaim verifies an HS512 token signed with secret. It does not
+// check revocations; use VerifyJwtClaim with a JwtVerifier for that.
 func ExtractJwtClaim[T any](jwtString string, secret string) (T, error) {
 	var zero T
+	if secret == "" {
+		return zero, errors.New("secret is empty")
+	}
+	return VerifyJwtClaim[T](jwtString, &JwtVerifier{Keys: HMACKey(secret), Algorithms: []string{"HS512"}})
+}
+
+// VerifyJwtClaim verifies a token with v and decodes its claims into T.
+func VerifyJwtClaim[T any](jwtString string, v *JwtVerifier) (T, error) {
+	var zero T
 
 	jwtString = strings.TrimSpace(jwtString)
 	if jwtString == "" {
 		return zero, errors.New("jwt string is empty")
 	}
-	if secret == "" {
-		return zero, errors.New("secret is empty")
+	if len(v.Algorithms) == 0 {
+		return zero, errors.New("no algorithm allowed")
 	}
 
+	opts := []jwt.ParserOption{jwt.WithValidMethods(v.Algorithms), jwt.WithLeeway(v.Leeway)}
+	if v.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.Issuer))
+	}
+	if v.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.Audience))
+	}
 	token, err := jwt.Parse(jwtString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		kid, _ := token.Header["kid"].(string)
+		keys, err := v.Keys.Keys(kid, token.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		switch len(keys) {
+		case 0:
+			return nil, fmt.Errorf("%w for kid %q and %s", ErrNoKey, kid, token.Method.Alg())
+		case 1:
+			return keys[0], nil
 		}
-		return []byte(secret), nil
-	}, jwt.WithValidMethods([]string{"HS512"}))
+		set := jwt.VerificationKeySet{}
+		for _, k := range keys {
+			set.Keys = append(set.Keys, k)
+		}
+		return set, nil
+	}, opts...)
 
 	if err != nil {
 		return zero, fmt.Errorf("failed to parse jwt: %w", err)
@@ -40,6 +81,15 @@ func ExtractJwtClaim[T any](jwtString string, secret string) (T, error) {
 	if !ok {
 		return zero, errors.New("invalid jwt claims format")
 	}
+	if jti, _ := claims["jti"].(string); jti != "" && v.Revocations != nil {
+		revoked, err := v.Revocations.Revoked(jti)
+		if err != nil {
+			return zero, fmt.Errorf("check revocation: %w", err)
+		}
+		if revoked {
+			return zero, ErrTokenRevoked
+		}
+	}
 
 	claimsBytes, err := json.Marshal(claims)
 	if err != nil {
@@ -0,0 +1,133 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"go-gin-example/internal/models"
+)
+
+const benchClients = 100000
+
+// benchHub registers benchClients sockets, one per user. Sockets evict
+// their oldest frame when full, so a benchmark measures routing rather
+// than readers.
+func benchHub(b *testing.B, shards int) *Hub {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	h := New(Options{Shards: shards, ResumeBuffer: 16})
+	for i := 0; i < benchClients; i++ {
+		h.registerClient(&Client{
+			ID:         fmt.Sprintf("c%d", i),
+			UserID:     fmt.Sprintf("u%d", i),
+			Send:       make(chan []byte, 16),
+			SlowPolicy: SlowDropOldest,
+		})
+	}
+	return h
+}
+
+func forShards(b *testing.B, fn func(b *testing.B, h *Hub)) {
+	for _, n := range []int{1, DefaultShards} {
+		b.Run(fmt.Sprintf("shards=%d", n), func(b *testing.B) {
+			fn(b, benchHub(b, n))
+		})
+	}
+}
+
+func BenchmarkDeliverDirect(b *testing.B) {
+	forShards(b, func(b *testing.B, h *Hub) {
+		var next atomic.Uint64
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				i := next.Add(1)
+				h.Deliver(&models.Message{
+					ID:          "m",
+					SenderID:    fmt.Sprintf("u%d", i%benchClients),
+					RecipientID: fmt.Sprintf("u%d", (i*7919)%benchClients),
+					Content:     "hello",
+					EventType:   models.EventTypeSent,
+				})
+			}
+		})
+	})
+}
+
+func BenchmarkDeliverGroup(b *testing.B) {
+	forShards(b, func(b *testing.B, h *Hub) {
+		const groups, members = 100, 1000
+		for g := 0; g < groups; g++ {
+			users := make([]string, members)
+			for m := range users {
+				users[m] = fmt.Sprintf("u%d", (g*members+m)%benchClients)
+			}
+			h.JoinGroup(fmt.Sprintf("g%d", g), users...)
+		}
+
+		var next atomic.Uint64
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				g := next.Add(1) % groups
+				h.Deliver(&models.Message{
+					ID:        "m",
+					SenderID:  fmt.Sprintf("u%d", g*members),
+					GroupID:   fmt.Sprintf("g%d", g),
+					Content:   "hello",
+					EventType: models.EventTypeSent,
+				})
+			}
+		})
+	})
+}
+
+// BenchmarkDeliverDuringReconnects measures deliveries while sockets of
+// other users keep reconnecting.
+func BenchmarkDeliverDuringReconnects(b *testing.B) {
+	forShards(b, func(b *testing.B, h *Hub) {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			r := rand.New(rand.NewSource(1))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c := &Client{ID: "r", UserID: fmt.Sprintf("u%d", r.Intn(benchClients)), Send: make(chan []byte, 16)}
+				h.registerClient(c)
+				h.unregisterClient(c)
+			}
+		}()
+
+		var next atomic.Uint64
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				i := next.Add(1)
+				h.Deliver(&models.Message{
+					ID:          "m",
+					RecipientID: fmt.Sprintf("u%d", (i*7919)%benchClients),
+					Content:     "hello",
+					EventType:   models.EventTypeSent,
+				})
+			}
+		})
+		b.StopTimer()
+		close(stop)
+		<-done
+	})
+}
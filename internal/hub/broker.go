@@ -0,0 +1,351 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// ======================
+// STOMP Broker Supervisor
+// ======================
+
+// The supervisor owns the broker connection. It dials, subscribes every
+// registered destination and, when the connection drops or heart-beats
+// stop, reconnects with exponential backoff and jitter and resubscribes.
+
+type BrokerState string
+
+const (
+	BrokerDisabled     BrokerState = "disabled"
+	BrokerConnecting   BrokerState = "connecting"
+	BrokerConnected    BrokerState = "connected"
+	BrokerReconnecting BrokerState = "reconnecting"
+	BrokerStopped      BrokerState = "stopped"
+)
+
+const (
+	brokerBackoffMin = 500 * time.Millisecond
+	brokerBackoffMax = 30 * time.Second
+	brokerHeartBeat  = 10 * time.Second
+)
+
+// brokerProbeInterval is how often a connected supervisor checks that the
+// connection is still open (see alive).
+var brokerProbeInterval = 5 * time.Second
+
+// brokerUnsubscribeTimeout bounds the wait for the RECEIPT of an
+// UNSUBSCRIBE. The frame itself goes out at once; brokers that never confirm
+// it must not hold up a shutdown.
+var brokerUnsubscribeTimeout = 2 * time.Second
+
+var ErrBrokerUnavailable = errors.New("STOMP broker unavailable")
+
+// BrokerHealth is a snapshot of the supervisor state.
+type BrokerHealth struct {
+	State         BrokerState `json:"state"`
+	Broker        string      `json:"broker,omitempty"`
+	Attempts      int         `json:"attempts"`   // consecutive failed dials
+	Reconnects    int         `json:"reconnects"` // successful reconnects since start
+	LastError     string      `json:"last_error,omitempty"`
+	ConnectedAt   string      `json:"connected_at,omitempty"`
+	Subscriptions []string    `json:"subscriptions,omitempty"`
+}
+
+type brokerSub struct {
+	dest    string
+	ack     stomp.AckMode
+	opts    []func(*frame.Frame) error
+	handle  func(msg *stomp.Message)
+	active  *stomp.Subscription
+	removed bool
+}
+
+type broker struct {
+	addr string
+	opts []func(*stomp.Conn) error
+
+	mu     sync.Mutex
+	conn   *stomp.Conn
+	subs   []*brokerSub
+	health BrokerHealth
+	lost   chan struct{} // closed when the current connection dies
+	ready  chan error    // first connection attempt result
+	done   chan struct{}
+	exited chan struct{}
+	once   sync.Once
+}
+
+func newBroker(addr string, opts ...func(*stomp.Conn) error) *broker {
+	return &broker{
+		addr:   addr,
+		opts:   opts,
+		health: BrokerHealth{State: BrokerConnecting, Broker: addr},
+		ready:  make(chan error, 1),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+}
+
+// subscribe registers a destination that survives reconnects. The returned
+// function removes it.
+func (b *broker) subscribe(dest string, ack stomp.AckMode, handle func(*stomp.Message), opts ...func(*frame.Frame) error) func() {
+	s := &brokerSub{dest: dest, ack: ack, opts: opts, handle: handle}
+
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	conn, lost := b.conn, b.lost
+	b.mu.Unlock()
+
+	if conn != nil {
+		if err := b.activate(conn, s, lost); err != nil {
+			log.Printf("STOMP subscribe %s failed: %v", dest, err)
+			b.markLost(lost)
+		}
+	}
+
+	return func() {
+		b.mu.Lock()
+		s.removed = true
+		active := s.active
+		for i, cur := range b.subs {
+			if cur == s {
+				b.subs = append(b.subs[:i], b.subs[i+1:]...)
+				break
+			}
+		}
+		b.mu.Unlock()
+		if active != nil {
+			_ = active.Unsubscribe()
+		}
+	}
+}
+
+// send publishes on the current connection.
+func (b *broker) send(dest, contentType string, body []byte, opts ...func(*frame.Frame) error) error {
+	b.mu.Lock()
+	conn := b.conn
+	b.mu.Unlock()
+	if conn == nil {
+		return ErrBrokerUnavailable
+	}
+	return conn.Send(dest, contentType, body, opts...)
+}
+
+func (b *broker) healthSnapshot() BrokerHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.health
+	h.Subscriptions = make([]string, 0, len(b.subs))
+	for _, s := range b.subs {
+		h.Subscriptions = append(h.Subscriptions, s.dest)
+	}
+	return h
+}
+
+// supervise runs until stop is called.
+func (b *broker) supervise() {
+	defer close(b.exited)
+
+	connectedBefore := false
+	for attempt := 0; ; {
+		conn, lost, err := b.connect()
+		if attempt == 0 && !connectedBefore {
+			b.ready <- err
+		}
+		if err != nil {
+			attempt++
+			b.setState(BrokerReconnecting, attempt, err)
+			log.Printf("STOMP connect to %s failed (attempt %d): %v", b.addr, attempt, err)
+			select {
+			case <-time.After(backoff(attempt)):
+				continue
+			case <-b.done:
+				return
+			}
+		}
+
+		attempt = 0
+		b.setState(BrokerConnected, 0, nil)
+		if connectedBefore {
+			b.mu.Lock()
+			b.health.Reconnects++
+			b.mu.Unlock()
+		}
+		connectedBefore = true
+		log.Printf("STOMP connected to %s", b.addr)
+
+		if !b.watch(conn, lost) {
+			return
+		}
+		b.drop(conn)
+		conn.MustDisconnect()
+		b.setState(BrokerReconnecting, 0, errors.New("connection lost"))
+		log.Printf("STOMP connection to %s lost, reconnecting", b.addr)
+	}
+}
+
+// watch blocks while conn is healthy. It returns false when the supervisor
+// is stopping, after disconnecting.
+func (b *broker) watch(conn *stomp.Conn, lost chan struct{}) bool {
+	probe := time.NewTicker(brokerProbeInterval)
+	defer probe.Stop()
+	for {
+		select {
+		case <-lost:
+			return true
+		case <-probe.C:
+			if !alive(conn) {
+				return true
+			}
+		case <-b.done:
+			b.drop(conn)
+			_ = conn.Disconnect()
+			return false
+		}
+	}
+}
+
+// alive reports whether conn still accepts frames. Subscriptions alone do
+// not reveal a dead connection: go-stomp drops a SUBSCRIBE still queued
+// when the connection closes and never ends that subscription's channel.
+// An empty transaction has no side effects on the broker.
+func alive(conn *stomp.Conn) bool {
+	tx, err := conn.BeginWithError()
+	if err != nil {
+		return false
+	}
+	return tx.Abort() == nil
+}
+
+// connect dials and activates every registered subscription.
+func (b *broker) connect() (*stomp.Conn, chan struct{}, error) {
+	opts := append([]func(*stomp.Conn) error{
+		stomp.ConnOpt.HeartBeat(brokerHeartBeat, brokerHeartBeat),
+		stomp.ConnOpt.UnsubscribeReceiptTimeout(brokerUnsubscribeTimeout),
+	}, b.opts...)
+	conn, err := stomp.Dial("tcp", b.addr, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lost := make(chan struct{})
+	b.mu.Lock()
+	subs := append([]*brokerSub(nil), b.subs...)
+	b.conn, b.lost = conn, lost
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		if err := b.activate(conn, s, lost); err != nil {
+			b.drop(conn)
+			conn.MustDisconnect()
+			return nil, nil, err
+		}
+	}
+	return conn, lost, nil
+}
+
+// activate subscribes s on conn and pumps its messages until the
+// subscription ends; an unexpected end marks the connection lost.
+func (b *broker) activate(conn *stomp.Conn, s *brokerSub, lost chan struct{}) error {
+	sub, err := conn.Subscribe(s.dest, s.ack, s.opts...)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	s.active = sub
+	b.mu.Unlock()
+
+	go func() {
+		for msg := range sub.C {
+			if msg.Err != nil {
+				log.Printf("STOMP subscription %s: %v", s.dest, msg.Err)
+				break
+			}
+			s.handle(msg)
+		}
+		b.mu.Lock()
+		removed := s.removed
+		b.mu.Unlock()
+		if !removed {
+			b.markLost(lost)
+		}
+	}()
+	return nil
+}
+
+func (b *broker) markLost(lost chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lost != nil && b.lost == lost {
+		close(lost)
+		b.lost = nil
+	}
+}
+
+// drop forgets conn if it is still current.
+func (b *broker) drop(conn *stomp.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == conn {
+		b.conn = nil
+		b.lost = nil
+	}
+	for _, s := range b.subs {
+		s.active = nil
+	}
+}
+
+func (b *broker) setState(state BrokerState, attempts int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.health.State = state
+	b.health.Attempts = attempts
+	if err != nil {
+		b.health.LastError = err.Error()
+	}
+	if state == BrokerConnected {
+		b.health.ConnectedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+}
+
+// stop unsubscribes every destination, ends supervision and disconnects.
+func (b *broker) stop() {
+	b.once.Do(func() {
+		b.mu.Lock()
+		var active []*stomp.Subscription
+		for _, s := range b.subs {
+			s.removed = true
+			if s.active != nil {
+				active = append(active, s.active)
+			}
+		}
+		b.mu.Unlock()
+		var wg sync.WaitGroup
+		for _, sub := range active {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = sub.Unsubscribe()
+			}()
+		}
+		wg.Wait()
+		close(b.done)
+		<-b.exited
+		b.setState(BrokerStopped, 0, nil)
+	})
+}
+
+// backoff returns an exponentially growing delay with jitter in [d/2, d].
+func backoff(attempt int) time.Duration {
+	d := brokerBackoffMin << min(attempt-1, 16)
+	if d <= 0 || d > brokerBackoffMax {
+		d = brokerBackoffMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
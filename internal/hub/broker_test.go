@@ -0,0 +1,500 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-gin-example/internal/models"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/go-stomp/stomp/v3/server"
+)
+
+// flakyProxy forwards TCP to target and can sever every open connection.
+type flakyProxy struct {
+	ln     net.Listener
+	target string
+	mu     sync.Mutex
+	conns  []net.Conn
+}
+
+func newFlakyProxy(t *testing.T, target string) *flakyProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &flakyProxy{ln: ln, target: target}
+	go func() {
+		for {
+			in, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			out, err := net.Dial("tcp", target)
+			if err != nil {
+				in.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, in, out)
+			p.mu.Unlock()
+			go io.Copy(in, out)
+			go io.Copy(out, in)
+		}
+	}()
+	t.Cleanup(func() { ln.Close(); p.sever() })
+	return p
+}
+
+func (p *flakyProxy) sever() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+func startStompServer(t *testing.T) string {
+	// The in-memory server never confirms an UNSUBSCRIBE.
+	unsubscribe := brokerUnsubscribeTimeout
+	brokerUnsubscribeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { brokerUnsubscribeTimeout = unsubscribe })
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+func TestBrokerReconnectsAndResubscribes(t *testing.T) {
+	probe := brokerProbeInterval
+	brokerProbeInterval = 20 * time.Millisecond
+	t.Cleanup(func() { brokerProbeInterval = probe })
+	proxy := newFlakyProxy(t, startStompServer(t))
+	b := newBroker(proxy.ln.Addr().String())
+
+	received := make(chan string, 8)
+	b.subscribe("/queue/test", stomp.AckAuto, func(msg *stomp.Message) {
+		received <- string(msg.Body)
+	})
+	go b.supervise()
+	defer b.stop()
+	if err := <-b.ready; err != nil {
+		t.Fatal(err)
+	}
+
+	proxy.sever()
+	waitFor(t, "reconnect", func() bool {
+		h := b.healthSnapshot()
+		return h.State == BrokerConnected && h.Reconnects == 1
+	})
+
+	if err := b.send("/queue/test", "text/plain", []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-received:
+		if got != "after" {
+			t.Fatalf("received %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message after resubscribe")
+	}
+}
+
+func TestBackoffGrowsAndCaps(t *testing.T) {
+	for attempt, max := range map[int]time.Duration{1: brokerBackoffMin, 3: 4 * brokerBackoffMin, 50: brokerBackoffMax} {
+		d := backoff(attempt)
+		if d < max/2 || d > max {
+			t.Errorf("backoff(%d) = %v, want within [%v, %v]", attempt, d, max/2, max)
+		}
+	}
+}
+
+func TestStartSTOMPRoutesPerDestination(t *testing.T) {
+	addr := startStompServer(t)
+	h := New(Options{})
+	go h.Run(context.Background())
+	defer h.StopSTOMP()
+
+	alice := newTestClient("a1", "alice")
+	bob := newTestClient("b1", "bob")
+	h.registerClient(alice)
+	h.registerClient(bob)
+
+	chat, _ := DestinationOf("chat", "/queue/chat", stomp.AckAuto, nil)
+	system, _ := DestinationOf("system", "/topic/notices", stomp.AckAuto, nil)
+	if err := h.StartSTOMP(addr, []Destination{chat, system}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := h.stompBroker()
+	if err := b.send("/queue/chat", "application/json", []byte(`{"recipient_id":"bob","content":"hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.send("/topic/notices", "application/json", []byte(`{"content":"maintenance at 02:00"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "deliveries", func() bool { return len(alice.Send) == 1 && len(bob.Send) == 2 })
+	expectEvent(t, alice, models.EventTypeSystemNotice)
+}
+
+// scriptedBroker speaks just enough STOMP 1.2 to push frames to one
+// subscriber and record what the client does with them. Like a real broker
+// it redelivers NACKed frames under their original message-id, and frames
+// sent to the subscribed destination come back as new messages. It never
+// answers a RECEIPT request on SEND.
+type scriptedBroker struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	w       *frame.Writer
+	sub     string
+	dest    string
+	next    int
+	pending map[string]scriptedFrame // by ack id
+	ready   chan struct{}
+	events  chan string // "ACK body", "NACK body", "SEND dest body"
+}
+
+type scriptedFrame struct {
+	id   string // message-id
+	body []byte
+}
+
+func newScriptedBroker(t *testing.T) *scriptedBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &scriptedBroker{ln: ln, pending: make(map[string]scriptedFrame), ready: make(chan struct{}), events: make(chan string, 64)}
+	go b.serve()
+	t.Cleanup(func() { ln.Close() })
+	return b
+}
+
+func (b *scriptedBroker) serve() {
+	conn, err := b.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := frame.NewReader(conn)
+	b.w = frame.NewWriter(conn)
+	for {
+		f, err := r.Read()
+		if err != nil {
+			return
+		}
+		if f == nil {
+			continue
+		}
+		switch f.Command {
+		case frame.CONNECT, frame.STOMP:
+			b.write(frame.New(frame.CONNECTED, frame.Version, "1.2"))
+		case frame.SUBSCRIBE:
+			b.mu.Lock()
+			b.sub = f.Header.Get(frame.Id)
+			b.dest = f.Header.Get(frame.Destination)
+			b.mu.Unlock()
+			close(b.ready)
+		case frame.ACK, frame.NACK:
+			b.mu.Lock()
+			sf := b.pending[f.Header.Get(frame.Id)]
+			delete(b.pending, f.Header.Get(frame.Id))
+			b.mu.Unlock()
+			b.events <- f.Command + " " + string(sf.body)
+			if f.Command == frame.NACK {
+				b.deliver(sf)
+			}
+		case frame.SEND:
+			b.events <- "SEND " + f.Header.Get(frame.Destination) + " " + string(f.Body)
+			b.mu.Lock()
+			loop := f.Header.Get(frame.Destination) == b.dest
+			b.mu.Unlock()
+			if loop {
+				b.publish(f.Body)
+			}
+		case frame.UNSUBSCRIBE:
+			if receipt, ok := f.Header.Contains(frame.Receipt); ok {
+				b.write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+			}
+		case frame.DISCONNECT:
+			b.write(frame.New(frame.RECEIPT, frame.ReceiptId, f.Header.Get(frame.Receipt)))
+			return
+		}
+	}
+}
+
+func (b *scriptedBroker) write(f *frame.Frame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = b.w.Write(f)
+}
+
+// publish pushes body as a new message.
+func (b *scriptedBroker) publish(body []byte) {
+	b.mu.Lock()
+	b.next++
+	id := "m" + strconv.Itoa(b.next)
+	b.mu.Unlock()
+	b.deliver(scriptedFrame{id: id, body: body})
+}
+
+func (b *scriptedBroker) deliver(sf scriptedFrame) {
+	b.mu.Lock()
+	b.next++
+	ack := strconv.Itoa(b.next)
+	b.pending[ack] = sf
+	sub := b.sub
+	b.mu.Unlock()
+
+	f := frame.New(frame.MESSAGE,
+		frame.Destination, "/queue/chat",
+		frame.MessageId, sf.id,
+		frame.Subscription, sub,
+		frame.Ack, ack,
+		frame.ContentType, "application/json")
+	f.Body = sf.body
+	b.write(f)
+}
+
+func (b *scriptedBroker) expect(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case got := <-b.events:
+		if got != want {
+			t.Fatalf("broker saw %q, want %q", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("broker never saw %q", want)
+	}
+}
+
+func TestDestinationNacksUntilAccepted(t *testing.T) {
+	redeliveryBackoff = time.Millisecond
+	broker := newScriptedBroker(t)
+	h := New(Options{})
+	defer h.StopSTOMP()
+
+	bob := &Client{ID: "b1", UserID: "bob", Send: make(chan []byte, 1)}
+	bob.Send <- []byte("backlog")
+	h.registerClient(bob)
+
+	chat := Destination{Name: "/queue/chat", Ack: stomp.AckClientIndividual}
+	if err := h.StartSTOMP(broker.ln.Addr().String(), []Destination{chat}); err != nil {
+		t.Fatal(err)
+	}
+	<-broker.ready
+
+	body := `{"recipient_id":"bob","content":"hi"}`
+	broker.publish([]byte(body))
+	broker.expect(t, "NACK "+body)
+	<-bob.Send // make room; the redelivered frame must land
+	waitFor(t, "redelivery", func() bool { return len(bob.Send) == 1 })
+	broker.expect(t, "ACK "+body)
+}
+
+func TestDestinationDeadLettersPoisonFrames(t *testing.T) {
+	redeliveryBackoff = time.Millisecond
+	broker := newScriptedBroker(t)
+	h := New(Options{})
+	defer h.StopSTOMP()
+
+	stuck := &Client{ID: "b1", UserID: "bob", Send: make(chan []byte)}
+	h.registerClient(stuck)
+
+	chat := Destination{Name: "/queue/chat", Ack: stomp.AckClientIndividual, DeadLetter: "/queue/dlq", MaxRedeliveries: 2}
+	if err := h.StartSTOMP(broker.ln.Addr().String(), []Destination{chat}); err != nil {
+		t.Fatal(err)
+	}
+	<-broker.ready
+
+	broker.publish([]byte("not json"))
+	broker.expect(t, "SEND /queue/dlq not json")
+	broker.expect(t, "ACK not json")
+
+	body := `{"recipient_id":"bob","content":"hi"}`
+	broker.publish([]byte(body))
+	broker.expect(t, "NACK "+body)
+	broker.expect(t, "NACK "+body)
+	broker.expect(t, "SEND /queue/dlq "+body)
+	broker.expect(t, "ACK "+body)
+}
+
+func TestDestinationAcksFramesSomeRecipientAccepted(t *testing.T) {
+	broker := newScriptedBroker(t)
+	h := New(Options{})
+	defer h.StopSTOMP()
+
+	stuck := &Client{ID: "b1", UserID: "bob", Send: make(chan []byte)}
+	bob := &Client{ID: "b2", UserID: "bob", Send: make(chan []byte, 1)}
+	h.registerClient(stuck)
+	h.registerClient(bob)
+
+	chat := Destination{Name: "/queue/chat", Ack: stomp.AckClientIndividual}
+	if err := h.StartSTOMP(broker.ln.Addr().String(), []Destination{chat}); err != nil {
+		t.Fatal(err)
+	}
+	<-broker.ready
+
+	body := `{"recipient_id":"bob","content":"hi"}`
+	broker.publish([]byte(body))
+	broker.expect(t, "ACK "+body)
+	if len(bob.Send) != 1 {
+		t.Fatal("the socket with room did not get the message")
+	}
+}
+
+func TestRedeliveriesAreCountedPerMessageID(t *testing.T) {
+	frameOf := func(id, body string) *stomp.Message {
+		m := &stomp.Message{Header: frame.NewHeader(), Body: []byte(body)}
+		if id != "" {
+			m.Header.Set(frame.MessageId, id)
+		}
+		return m
+	}
+
+	r := newRedeliveries("/queue/chat", false)
+	if r.key(frameOf("1", "hi")) == r.key(frameOf("2", "hi")) {
+		t.Fatal("frames with the same body share a counter")
+	}
+	if r.key(frameOf("1", "hi")) != r.key(frameOf("1", "hi")) {
+		t.Fatal("a redelivered frame got a new counter")
+	}
+	if r.key(frameOf("", "hi")) != r.key(frameOf("", "hi")) {
+		t.Fatal("frames without a message-id are not counted by body")
+	}
+	if r.key(frameOf("1", "hi")) == newRedeliveries("/queue/other", false).key(frameOf("1", "hi")) {
+		t.Fatal("destinations share counters")
+	}
+
+	rewriting := newRedeliveries("/queue/chat", true)
+	if rewriting.key(frameOf("1", "hi")) != rewriting.key(frameOf("2", "hi")) {
+		t.Fatal("redeliveries under a rewritten message-id are not counted together")
+	}
+}
+
+func TestInboundPublishDoesNotBlockTheSender(t *testing.T) {
+	broker := newScriptedBroker(t)
+	h := New(Options{})
+	h.Start(t.Context())
+	defer h.StopSTOMP()
+
+	bob := &Client{ID: "b1", UserID: "bob", Send: make(chan []byte, 4)}
+	h.registerClient(bob)
+	if err := h.StartSTOMP(broker.ln.Addr().String(), nil, stomp.ConnOpt.RcvReceiptTimeout(2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	h.SetPublishing(PublishConfig{Destination: "/queue/events", Receipts: true})
+
+	start := time.Now()
+	msg, err := h.HandleInbound(&Client{ID: "a1", UserID: "alice"}, []byte(`{"recipient_id":"bob","content":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second/2 {
+		t.Fatal("HandleInbound waited for the broker's receipt")
+	}
+	waitFor(t, "local delivery", func() bool { return len(bob.Send) == 1 })
+	select {
+	case got := <-broker.events:
+		if !strings.HasPrefix(got, "SEND /queue/events ") || !strings.Contains(got, msg.ID) {
+			t.Fatalf("broker saw %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message never published")
+	}
+}
+
+func TestInboundPublishLoopingBackIsDeliveredOnce(t *testing.T) {
+	broker := newScriptedBroker(t)
+	h := New(Options{})
+	h.Start(t.Context())
+	defer h.StopSTOMP()
+
+	bob := &Client{ID: "b1", UserID: "bob", Send: make(chan []byte, 4)}
+	h.registerClient(bob)
+	chat := Destination{Name: "/queue/chat", Ack: stomp.AckClientIndividual}
+	if err := h.StartSTOMP(broker.ln.Addr().String(), []Destination{chat}); err != nil {
+		t.Fatal(err)
+	}
+	<-broker.ready
+	h.SetPublishing(PublishConfig{Destination: "/queue/chat"})
+
+	msg, err := h.HandleInbound(&Client{ID: "a1", UserID: "alice"}, []byte(`{"recipient_id":"bob","content":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"SEND /queue/chat ", "ACK "} {
+		select {
+		case got := <-broker.events:
+			if !strings.HasPrefix(got, want) || !strings.Contains(got, msg.ID) {
+				t.Fatalf("broker saw %q, want %q…", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("broker never saw %q…", want)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(bob.Send); n != 1 {
+		t.Fatalf("recipient got the message %d times", n)
+	}
+}
+
+func TestPublishRoutesByEventTypeWithReceipt(t *testing.T) {
+	addr := startStompServer(t)
+	h := New(Options{})
+	defer h.StopSTOMP()
+	if err := h.StartSTOMP(addr, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.SetPublishing(PublishConfig{
+		Destination: "/queue/events",
+		Routes:      map[string]string{models.EventTypeDeleted: "/queue/moderation"},
+		Receipts:    true,
+	})
+
+	got := make(chan *stomp.Message, 1)
+	h.stompBroker().subscribe("/queue/moderation", stomp.AckAuto, func(msg *stomp.Message) { got <- msg })
+
+	msg := &models.Message{RecipientID: "bob", EventType: models.EventTypeDeleted}
+	Stamp("alice", msg)
+	res, err := h.Publish(msg, PublishOptions{Headers: map[string]string{"tenant": "acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Destination != "/queue/moderation" || res.CorrelationID != msg.ID || !res.Receipt {
+		t.Fatalf("result %+v", res)
+	}
+
+	select {
+	case m := <-got:
+		if m.Header.Get("correlation-id") != msg.ID || m.Header.Get("event-type") != models.EventTypeDeleted || m.Header.Get("tenant") != "acme" {
+			t.Fatalf("headers %v", m.Header)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing published")
+	}
+
+	if _, err := h.Publish(msg, PublishOptions{Headers: map[string]string{"destination": "/queue/x"}}); !errors.Is(err, ErrReservedHeader) {
+		t.Fatalf("err = %v, want ErrReservedHeader", err)
+	}
+	if _, err := h.Publish(msg, PublishOptions{Destination: "/topic/ws-chat.node.n2"}); !errors.Is(err, ErrDestinationForbidden) {
+		t.Fatalf("err = %v, want ErrDestinationForbidden", err)
+	}
+	if res, err := h.Publish(msg, PublishOptions{Destination: "/queue/events"}); err != nil || res.Destination != "/queue/events" {
+		t.Fatalf("configured destination: %+v, %v", res, err)
+	}
+}
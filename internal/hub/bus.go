@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/go-stomp/stomp/v3"
+)
+
+// ======================
+// Cluster Bus
+// ======================
+
+// Bus carries hub-to-hub traffic between instances. Subjects are short
+// dotted names ("directory", "node.<id>"); implementations map them onto
+// their own addressing.
+type Bus interface {
+	Publish(subject string, body []byte) error
+	Subscribe(subject string, fn func(body []byte)) (unsubscribe func(), err error)
+	Close() error
+}
+
+var ErrBusClosed = errors.New("bus closed")
+
+// LoopbackBus is an in-process Bus. Hubs sharing one instance behave like
+// separate nodes, which is handy for tests and single-binary setups.
+// Every subscriber has its own ordered queue so a slow handler never
+// blocks the publisher.
+type LoopbackBus struct {
+	mu     sync.RWMutex
+	subs   map[string]map[*loopbackSub]struct{}
+	closed bool
+}
+
+type loopbackSub struct {
+	queue chan []byte
+	done  chan struct{}
+	once  sync.Once
+}
+
+func NewLoopbackBus() *LoopbackBus {
+	return &LoopbackBus{subs: make(map[string]map[*loopbackSub]struct{})}
+}
+
+func (b *LoopbackBus) Publish(subject string, body []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrBusClosed
+	}
+	for s := range b.subs[subject] {
+		select {
+		case s.queue <- body:
+		default:
+			log.Printf("Loopback bus: dropping frame on %s", subject)
+		}
+	}
+	return nil
+}
+
+func (b *LoopbackBus) Subscribe(subject string, fn func(body []byte)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	s := &loopbackSub{queue: make(chan []byte, 1024), done: make(chan struct{})}
+	if b.subs[subject] == nil {
+		b.subs[subject] = make(map[*loopbackSub]struct{})
+	}
+	b.subs[subject][s] = struct{}{}
+
+	go func() {
+		for {
+			select {
+			case body := <-s.queue:
+				fn(body)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.subs[subject], s)
+		b.mu.Unlock()
+		s.once.Do(func() { close(s.done) })
+	}, nil
+}
+
+func (b *LoopbackBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, set := range b.subs {
+		for s := range set {
+			s.once.Do(func() { close(s.done) })
+		}
+	}
+	b.subs = make(map[string]map[*loopbackSub]struct{})
+	return nil
+}
+
+// StompBus runs the cluster bus over the broker connection started by
+// InitSTOMP, so it follows the supervisor through reconnects. Subjects
+// become topics under prefix, e.g. "/topic/ws-chat.".
+type StompBus struct {
+	broker *broker
+	prefix string
+
+	mu     sync.Mutex
+	unsubs map[int]func()
+	next   int
+}
+
+// STOMPBus returns a cluster bus sharing the hub's broker connection.
+func (h *Hub) STOMPBus(prefix string) (*StompBus, error) {
+	b := h.stompBroker()
+	if b == nil {
+		return nil, errors.New("STOMP is not initialised")
+	}
+	if prefix == "" {
+		prefix = "/topic/ws-chat."
+	}
+	return &StompBus{broker: b, prefix: prefix, unsubs: make(map[int]func())}, nil
+}
+
+func (b *StompBus) Publish(subject string, body []byte) error {
+	return b.broker.send(b.prefix+subject, "application/json", body)
+}
+
+func (b *StompBus) Subscribe(subject string, fn func(body []byte)) (func(), error) {
+	unsub := b.broker.subscribe(b.prefix+subject, stomp.AckAuto, func(msg *stomp.Message) {
+		fn(msg.Body)
+	})
+
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.unsubs[id] = unsub
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		delete(b.unsubs, id)
+		b.mu.Unlock()
+		unsub()
+	}, nil
+}
+
+// Close drops the bus subscriptions; the broker connection belongs to the hub.
+func (b *StompBus) Close() error {
+	b.mu.Lock()
+	unsubs := b.unsubs
+	b.unsubs = make(map[int]func())
+	b.mu.Unlock()
+	for _, unsub := range unsubs {
+		unsub()
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gofrs/uuid"
+)
+
+// ======================
+// Cluster Routing
+// ======================
+
+// Every node announces which users it holds on the "directory" subject and
+// receives deliveries for its users on "node.<id>". A message entering any
+// node is delivered to local sockets and forwarded once to each remote node
+// holding a recipient; forwarded messages are only delivered locally.
+//
+// Revoked token IDs go out on "revoked" so every node closes the sockets
+// opened with them.
+//
+// Rooms are node-local; they only label connections and do not take part
+// in routing.
+
+const (
+	subjectDirectory  = "directory"
+	subjectGroups     = "groups"
+	subjectRevoked    = "revoked"
+	subjectNodePrefix = "node."
+
+	clusterRefresh    = 15 * time.Second
+	clusterNodeTTL    = 3 * clusterRefresh
+	clusterOutboxSize = 4096
+)
+
+type directoryEvent struct {
+	Node     string   `json:"node"`
+	Users    []string `json:"users,omitempty"`
+	Online   bool     `json:"online,omitempty"`
+	Snapshot bool     `json:"snapshot,omitempty"` // Users is the node's full list
+	Sync     bool     `json:"sync,omitempty"`     // peers should reply with snapshots
+	Leaving  bool     `json:"leaving,omitempty"`
+}
+
+type groupEvent struct {
+	Node     string              `json:"node"`
+	Group    string              `json:"group,omitempty"`
+	Owner    string              `json:"owner,omitempty"` // set when the group is created
+	Users    []string            `json:"users,omitempty"`
+	Join     bool                `json:"join,omitempty"`
+	Invite   bool                `json:"invite,omitempty"` // Users were invited
+	Snapshot map[string][]string `json:"snapshot,omitempty"`
+	Owners   map[string]string   `json:"owners,omitempty"` // owners of the snapshot's groups
+}
+
+type revokedEvent struct {
+	Node   string   `json:"node"`
+	Tokens []string `json:"tokens"`
+}
+
+type forwardEnvelope struct {
+	Node    string          `json:"node"`
+	Users   []string        `json:"users"`
+	Message *models.Message `json:"message"`
+}
+
+type busFrame struct {
+	subject string
+	body    []byte
+}
+
+type cluster struct {
+	hub    *Hub
+	bus    Bus
+	nodeID string
+	outbox chan busFrame
+	unsubs []func()
+	done   chan struct{}
+	once   sync.Once
+
+	mu        sync.RWMutex
+	users     map[string]map[string]struct{} // userID → remote nodeIDs
+	nodeUsers map[string]map[string]struct{} // remote nodeID → userIDs
+	seen      map[string]time.Time           // remote nodeID → last heard
+}
+
+// EnableCluster attaches the hub to a cluster bus. nodeID must be unique per
+// instance; an empty one is generated.
+func (h *Hub) EnableCluster(bus Bus, nodeID string) error {
+	if nodeID == "" {
+		id, _ := uuid.NewV4()
+		nodeID = id.String()
+	}
+	c := &cluster{
+		hub:       h,
+		bus:       bus,
+		nodeID:    nodeID,
+		outbox:    make(chan busFrame, clusterOutboxSize),
+		done:      make(chan struct{}),
+		users:     make(map[string]map[string]struct{}),
+		nodeUsers: make(map[string]map[string]struct{}),
+		seen:      make(map[string]time.Time),
+	}
+
+	subs := map[string]func([]byte){
+		subjectDirectory:           c.onDirectory,
+		subjectGroups:              c.onGroups,
+		subjectRevoked:             c.onRevoked,
+		subjectNodePrefix + nodeID: c.onForward,
+	}
+	for subject, fn := range subs {
+		unsub, err := bus.Subscribe(subject, fn)
+		if err != nil {
+			c.stop()
+			return err
+		}
+		c.unsubs = append(c.unsubs, unsub)
+	}
+
+	h.mu.Lock()
+	if h.cluster != nil {
+		h.mu.Unlock()
+		c.stop()
+		return errors.New("cluster already enabled")
+	}
+	h.cluster = c
+	h.mu.Unlock()
+
+	go c.run()
+	c.publish(subjectDirectory, directoryEvent{Node: nodeID, Sync: true})
+	c.publishSnapshot()
+	log.Printf("Cluster node %s joined", nodeID)
+	return nil
+}
+
+// NodeID returns this instance's cluster node ID, or "" when standalone.
+func (h *Hub) NodeID() string {
+	if h.cluster == nil {
+		return ""
+	}
+	return h.cluster.nodeID
+}
+
+// run drains the outbox and refreshes the directory periodically.
+func (c *cluster) run() {
+	ticker := time.NewTicker(clusterRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case f := <-c.outbox:
+			if err := c.bus.Publish(f.subject, f.body); err != nil {
+				log.Printf("Cluster publish %s failed: %v", f.subject, err)
+			}
+		case <-ticker.C:
+			c.publishSnapshot()
+			c.expireNodes()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// stop announces departure and releases the bus subscriptions.
+func (c *cluster) stop() {
+	c.once.Do(func() {
+		data, _ := json.Marshal(directoryEvent{Node: c.nodeID, Leaving: true})
+		_ = c.bus.Publish(subjectDirectory, data)
+		for _, unsub := range c.unsubs {
+			unsub()
+		}
+		close(c.done)
+	})
+}
+
+// publish queues a frame without blocking the caller, which usually holds
+// hub locks, and reports whether it was queued. A full outbox drops the
+// frame; the periodic snapshot repairs the directory.
+func (c *cluster) publish(subject string, v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	select {
+	case c.outbox <- busFrame{subject: subject, body: data}:
+		return true
+	default:
+		log.Printf("Cluster outbox full: dropping %s", subject)
+		return false
+	}
+}
+
+func (c *cluster) announce(userID string, online bool) {
+	if c == nil {
+		return
+	}
+	c.publish(subjectDirectory, directoryEvent{Node: c.nodeID, Users: []string{userID}, Online: online})
+}
+
+func (c *cluster) publishGroup(groupID string, userIDs []string, join bool) {
+	c.publishGroupEvent(groupEvent{Group: groupID, Users: userIDs, Join: join})
+}
+
+func (c *cluster) publishGroupEvent(ev groupEvent) {
+	if c == nil {
+		return
+	}
+	ev.Node = c.nodeID
+	c.publish(subjectGroups, ev)
+}
+
+func (c *cluster) publishRevoked(tokenIDs []string) {
+	if c == nil || len(tokenIDs) == 0 {
+		return
+	}
+	c.publish(subjectRevoked, revokedEvent{Node: c.nodeID, Tokens: tokenIDs})
+}
+
+func (c *cluster) publishSnapshot() {
+	h := c.hub
+	local := make(map[string]bool)
+	for _, s := range h.shards {
+		s.mu.RLock()
+		for uid := range s.clients {
+			local[uid] = true
+		}
+		s.mu.RUnlock()
+	}
+	users := make([]string, 0, len(local))
+	for uid := range local {
+		users = append(users, uid)
+	}
+	h.presence.mu.Lock()
+	for uid := range h.presence.offline {
+		if !local[uid] {
+			users = append(users, uid)
+		}
+	}
+	h.presence.mu.Unlock()
+
+	c.publish(subjectDirectory, directoryEvent{Node: c.nodeID, Users: users, Snapshot: true})
+}
+
+func (c *cluster) publishGroupSnapshot() {
+	h := c.hub
+	h.groupMu.RLock()
+	snapshot := make(map[string][]string, len(h.groups))
+	for gid, members := range h.groups {
+		for uid := range members {
+			snapshot[gid] = append(snapshot[gid], uid)
+		}
+	}
+	owners := make(map[string]string, len(h.owners))
+	for gid, owner := range h.owners {
+		owners[gid] = owner
+	}
+	h.groupMu.RUnlock()
+
+	c.publish(subjectGroups, groupEvent{Node: c.nodeID, Snapshot: snapshot, Owners: owners})
+}
+
+// remoteNodes returns the other nodes holding userID.
+func (c *cluster) remoteNodes(userID string) []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]string, 0, len(c.users[userID]))
+	for node := range c.users[userID] {
+		out = append(out, node)
+	}
+	return out
+}
+
+// forward sends msg to every remote node holding one of userIDs. It returns
+// the number of nodes and whether every envelope was queued.
+func (c *cluster) forward(msg *models.Message, userIDs []string) (nodes int, ok bool) {
+	if c == nil || len(userIDs) == 0 {
+		return 0, true
+	}
+	byNode := make(map[string][]string)
+	c.mu.RLock()
+	for _, uid := range userIDs {
+		for node := range c.users[uid] {
+			byNode[node] = append(byNode[node], uid)
+		}
+	}
+	c.mu.RUnlock()
+
+	ok = true
+	for node, users := range byNode {
+		if !c.publish(subjectNodePrefix+node, forwardEnvelope{Node: c.nodeID, Users: users, Message: msg}) {
+			ok = false
+		}
+	}
+	return len(byNode), ok
+}
+
+func (c *cluster) onForward(body []byte) {
+	var env forwardEnvelope
+	if err := json.Unmarshal(body, &env); err != nil || env.Message == nil {
+		log.Printf("Cluster: bad forward envelope: %v", err)
+		return
+	}
+	c.hub.deliverToUsers(env.Message, env.Users)
+}
+
+func (c *cluster) onDirectory(body []byte) {
+	var ev directoryEvent
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Node == "" {
+		return
+	}
+	if ev.Node == c.nodeID {
+		return
+	}
+	if ev.Sync {
+		c.publishSnapshot()
+		c.publishGroupSnapshot()
+		return
+	}
+
+	c.mu.Lock()
+	var changed []string
+	switch {
+	case ev.Leaving:
+		changed = c.replaceNodeLocked(ev.Node, nil)
+		delete(c.seen, ev.Node)
+	case ev.Snapshot:
+		changed = c.replaceNodeLocked(ev.Node, ev.Users)
+		c.seen[ev.Node] = time.Now()
+	default:
+		for _, uid := range ev.Users {
+			if c.setLocked(uid, ev.Node, ev.Online) {
+				changed = append(changed, uid)
+			}
+		}
+		c.seen[ev.Node] = time.Now()
+	}
+	c.mu.Unlock()
+
+	c.hub.remotePresenceChanged(changed)
+}
+
+func (c *cluster) onGroups(body []byte) {
+	var ev groupEvent
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Node == c.nodeID {
+		return
+	}
+	h := c.hub
+	for gid, users := range ev.Snapshot {
+		h.setGroup(gid, ev.Owners[gid], users)
+	}
+	switch {
+	case ev.Group == "":
+	case ev.Owner != "":
+		h.createGroup(ev.Group, ev.Owner)
+	case ev.Invite:
+		h.inviteGroup(ev.Group, ev.Users)
+	case ev.Join:
+		h.joinGroup(ev.Group, ev.Users)
+	default:
+		h.leaveGroup(ev.Group, ev.Users)
+	}
+}
+
+func (c *cluster) onRevoked(body []byte) {
+	var ev revokedEvent
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Node == c.nodeID {
+		return
+	}
+	if n := c.hub.disconnectTokens(ev.Tokens); n > 0 {
+		log.Printf("Closed %d sockets of tokens revoked on node %s", n, ev.Node)
+	}
+}
+
+// expireNodes forgets nodes that stopped refreshing (crashed instances).
+func (c *cluster) expireNodes() {
+	c.mu.Lock()
+	var changed []string
+	for node, at := range c.seen {
+		if time.Since(at) > clusterNodeTTL {
+			log.Printf("Cluster node %s expired", node)
+			changed = append(changed, c.replaceNodeLocked(node, nil)...)
+			delete(c.seen, node)
+		}
+	}
+	c.mu.Unlock()
+
+	c.hub.remotePresenceChanged(changed)
+}
+
+// replaceNodeLocked sets the full user list of a node and returns users
+// whose remote presence flipped. Callers must hold c.mu.
+func (c *cluster) replaceNodeLocked(node string, users []string) []string {
+	next := make(map[string]struct{}, len(users))
+	for _, uid := range users {
+		next[uid] = struct{}{}
+	}
+	var changed []string
+	for uid := range c.nodeUsers[node] {
+		if _, ok := next[uid]; !ok && c.setLocked(uid, node, false) {
+			changed = append(changed, uid)
+		}
+	}
+	for uid := range next {
+		if c.setLocked(uid, node, true) {
+			changed = append(changed, uid)
+		}
+	}
+	return changed
+}
+
+// setLocked records whether node holds userID and reports whether the user
+// went from no remote nodes to some, or the reverse. Callers must hold c.mu.
+func (c *cluster) setLocked(userID, node string, online bool) bool {
+	before := len(c.users[userID])
+	if online {
+		if c.users[userID] == nil {
+			c.users[userID] = make(map[string]struct{})
+		}
+		if c.nodeUsers[node] == nil {
+			c.nodeUsers[node] = make(map[string]struct{})
+		}
+		c.users[userID][node] = struct{}{}
+		c.nodeUsers[node][userID] = struct{}{}
+	} else {
+		delete(c.users[userID], node)
+		if len(c.users[userID]) == 0 {
+			delete(c.users, userID)
+		}
+		delete(c.nodeUsers[node], userID)
+		if len(c.nodeUsers[node]) == 0 {
+			delete(c.nodeUsers, node)
+		}
+	}
+	return (before == 0) != (len(c.users[userID]) == 0)
+}
@@ -0,0 +1,114 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"go-gin-example/internal/models"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClusterForwardsToRemoteNode(t *testing.T) {
+	bus := NewLoopbackBus()
+	defer bus.Close()
+	a, b := New(Options{}), New(Options{})
+	if err := a.EnableCluster(bus, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnableCluster(bus, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	bob := newTestClient("b1", "bob")
+	b.registerClient(bob)
+	waitFor(t, "directory", func() bool { return len(a.cluster.remoteNodes("bob")) == 1 })
+
+	if got := a.Presence("bob")[0]; !got.Online {
+		t.Fatal("remote user not reported online")
+	}
+
+	a.JoinGroup("g1", "bob")
+	waitFor(t, "group replication", func() bool { return b.IsGroupMember("g1", "bob") })
+
+	a.broadcastMessage(&models.Message{ID: "m1", RecipientID: "bob", Content: "hi"})
+	waitFor(t, "forwarded delivery", func() bool { return len(bob.Send) == 1 })
+
+	b.unregisterClient(bob)
+	b.presence.mu.Lock()
+	b.presence.offline["bob"].Reset(0)
+	b.presence.mu.Unlock()
+	waitFor(t, "offline announcement", func() bool { return len(a.cluster.remoteNodes("bob")) == 0 })
+}
+
+func TestClusterDisconnectsRevokedTokens(t *testing.T) {
+	bus := NewLoopbackBus()
+	defer bus.Close()
+	a, b := New(Options{}), New(Options{})
+	if err := a.EnableCluster(bus, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnableCluster(bus, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	bob := newTestClient("b1", "bob")
+	bob.TokenID = "t1"
+	b.registerClient(bob)
+
+	if n := a.DisconnectTokens("t1"); n != 0 {
+		t.Fatalf("closed %d local sockets, want 0", n)
+	}
+	waitFor(t, "remote disconnect", bob.released)
+}
+
+func TestClusterGroupSnapshotReplacesMembers(t *testing.T) {
+	bus := NewLoopbackBus()
+	defer bus.Close()
+	a, b := New(Options{}), New(Options{})
+	if err := a.EnableCluster(bus, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnableCluster(bus, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// b missed bob leaving g1.
+	a.joinGroup("g1", []string{"alice"})
+	b.joinGroup("g1", []string{"alice", "bob"})
+
+	a.cluster.publishGroupSnapshot()
+	waitFor(t, "snapshot", func() bool { return !b.IsGroupMember("g1", "bob") })
+	if !b.IsGroupMember("g1", "alice") {
+		t.Fatal("snapshot dropped a member")
+	}
+}
+
+func TestClusterReplicatesGroupOwnersAndInvites(t *testing.T) {
+	bus := NewLoopbackBus()
+	defer bus.Close()
+	a, b := New(Options{}), New(Options{})
+	if err := a.EnableCluster(bus, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnableCluster(bus, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	gid, _ := a.CreateGroup("alice", "bob")
+	waitFor(t, "group creation", func() bool { return b.GroupOwner(gid) == "alice" })
+	waitFor(t, "invitation", func() bool {
+		joined, _, err := b.AddGroupMembers(gid, "bob", []string{"bob"})
+		return err == nil && len(joined) == 1
+	})
+	waitFor(t, "join", func() bool { return a.IsGroupMember(gid, "bob") })
+}
@@ -0,0 +1,189 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// ======================
+// Connections
+// ======================
+
+// Every socket has a unique ID and the device metadata captured at the
+// upgrade, so support can list a user's devices on this instance and
+// message or disconnect one of them.
+
+// CloseDisconnected is the WebSocket close code of sockets disconnected
+// through Disconnect.
+const CloseDisconnected = 4009
+
+// CloseTokenRevoked closes sockets whose token was revoked; the client must
+// sign in again rather than reconnect with the same token.
+const CloseTokenRevoked = 4010
+
+// maxCloseReason is the longest reason a close frame can carry.
+const maxCloseReason = 123
+
+var ErrUnknownConnection = errors.New("no such connection on this instance")
+
+// DeviceInfo describes the device behind a socket.
+type DeviceInfo struct {
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Platform    string    `json:"platform,omitempty"`
+	AppVersion  string    `json:"app_version,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// ConnectionInfo is a snapshot of one socket.
+type ConnectionInfo struct {
+	ID       string     `json:"id"`
+	UserID   string     `json:"user_id"`
+	RoomID   string     `json:"room_id,omitempty"`
+	Endpoint string     `json:"endpoint"` // ws | stomp
+	Device   DeviceInfo `json:"device"`
+	Buffered int        `json:"buffered"` // frames waiting in the send buffer
+}
+
+func (h *Hub) info(c *Client) ConnectionInfo {
+	endpoint := EndpointWS
+	if c.Codec != nil {
+		endpoint = EndpointSTOMP
+	}
+	h.groupMu.RLock() // JoinRoom may be moving the socket
+	roomID := c.RoomID
+	h.groupMu.RUnlock()
+	return ConnectionInfo{
+		ID:       c.ID,
+		UserID:   c.UserID,
+		RoomID:   roomID,
+		Endpoint: endpoint,
+		Device:   c.Device,
+		Buffered: len(c.Send),
+	}
+}
+
+// Connections lists the sockets of userID on this instance, or every
+// socket when userID is empty.
+func (h *Hub) Connections(userID string) []ConnectionInfo {
+	var out []ConnectionInfo
+	if userID != "" {
+		for _, c := range h.GetClientsByUser(userID) {
+			out = append(out, h.info(c))
+		}
+		return out
+	}
+	for _, c := range h.allClients() {
+		out = append(out, h.info(c))
+	}
+	return out
+}
+
+// Connection returns the socket with the given ID.
+func (h *Hub) Connection(id string) (ConnectionInfo, bool) {
+	c := h.connection(id)
+	if c == nil {
+		return ConnectionInfo{}, false
+	}
+	return h.info(c), true
+}
+
+func (h *Hub) connection(id string) *Client {
+	for _, s := range h.shards {
+		s.mu.RLock()
+		for _, list := range s.clients {
+			for _, c := range list {
+				if c.ID == id {
+					s.mu.RUnlock()
+					return c
+				}
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return nil
+}
+
+// DeliverToConnection queues msg on one socket only. The message is not
+// sequenced, so the user's other devices see no gap and it is never
+// replayed.
+func (h *Hub) DeliverToConnection(id string, msg *models.Message) (DeliveryReport, error) {
+	if h.Draining() {
+		return DeliveryReport{}, errHubStopped
+	}
+	c := h.connection(id)
+	if c == nil {
+		return DeliveryReport{}, ErrUnknownConnection
+	}
+	data, _ := json.Marshal(msg)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := h.shardFor(c.UserID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.registeredLocked(c) {
+		return DeliveryReport{}, ErrUnknownConnection
+	}
+	var r DeliveryReport
+	h.countSend(&r, c, msg, data)
+	if r.Dropped > 0 {
+		return r, ErrDeliveryIncomplete
+	}
+	return r, nil
+}
+
+// Disconnect closes one socket with CloseDisconnected after flushing what
+// it has buffered. The client may reconnect.
+func (h *Hub) Disconnect(id, reason string) error {
+	c := h.connection(id)
+	if c == nil {
+		return ErrUnknownConnection
+	}
+	if len(reason) > maxCloseReason {
+		cut := maxCloseReason
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	c.release(websocket.FormatCloseMessage(CloseDisconnected, reason), time.Now().Add(time.Second))
+	return nil
+}
+
+// DisconnectTokens closes the sockets opened with one of the given token
+// IDs, on this instance and on every other cluster node, and returns how
+// many it closed here.
+func (h *Hub) DisconnectTokens(tokenIDs ...string) int {
+	h.cluster.publishRevoked(tokenIDs)
+	return h.disconnectTokens(tokenIDs)
+}
+
+// disconnectTokens closes the sockets on this instance opened with one of
+// tokenIDs.
+func (h *Hub) disconnectTokens(tokenIDs []string) int {
+	revoked := make(map[string]bool, len(tokenIDs))
+	for _, id := range tokenIDs {
+		if id != "" {
+			revoked[id] = true
+		}
+	}
+	if len(revoked) == 0 {
+		return 0
+	}
+	closed := 0
+	frame := websocket.FormatCloseMessage(CloseTokenRevoked, "token revoked")
+	for _, c := range h.allClients() {
+		if revoked[c.TokenID] {
+			c.release(frame, time.Now().Add(time.Second))
+			closed++
+		}
+	}
+	return closed
+}
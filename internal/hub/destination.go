@@ -0,0 +1,341 @@
+package hub
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/models"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// ======================
+// Broker Destinations
+// ======================
+
+var errHubStopped = errors.New("hub stopped")
+
+const (
+	// DefaultMaxRedeliveries is how often a frame is NACKed before it is
+	// dead-lettered when Destination.MaxRedeliveries is zero.
+	DefaultMaxRedeliveries = 5
+	redeliveryTrackMax     = 10000
+)
+
+// redeliveryBackoff delays each NACK by attempt × backoff (capped at 2s) so
+// an overloaded hub is not handed the same frame in a tight loop.
+var redeliveryBackoff = 100 * time.Millisecond
+
+// Decoder turns a broker frame into hub messages.
+type Decoder func(msg *stomp.Message) ([]*models.Message, error)
+
+// Handler delivers decoded messages.
+type Handler func(h *Hub, msgs []*models.Message) error
+
+// Destination describes one broker subscription: its ack mode, extra
+// SUBSCRIBE headers (e.g. a JMS "selector") and how its frames are decoded
+// and delivered. Nil Decode/Handle mean chat events.
+//
+// Frames that cannot be decoded, or that still fail after MaxRedeliveries
+// NACKs, are sent to DeadLetter and acked. Without a DeadLetter they are
+// logged and dropped. Attempts are counted per message-id; set
+// RewritesMessageIDs for brokers that assign a new one on every
+// redelivery, so attempts are counted per body instead.
+type Destination struct {
+	Name               string
+	Ack                stomp.AckMode
+	Headers            map[string]string
+	Decode             Decoder
+	Handle             Handler
+	DeadLetter         string
+	MaxRedeliveries    int
+	RewritesMessageIDs bool
+
+	chat bool // built for the chat kind
+}
+
+// Kind bundles the decoder and handler of an event family.
+type Kind struct {
+	Decode Decoder
+	Handle Handler
+}
+
+// Kinds are the event families a destination can carry, by config name.
+var Kinds = map[string]Kind{
+	"chat":     {Decode: DecodeMessage, Handle: RouteMessages},
+	"presence": {Decode: DecodePresence, Handle: RoutePresence},
+	"system":   {Decode: DecodeSystemNotice, Handle: RouteSystemNotices},
+}
+
+// DestinationOf builds a Destination for a named kind.
+func DestinationOf(kind, name string, ack stomp.AckMode, headers map[string]string) (Destination, error) {
+	k, ok := Kinds[kind]
+	if !ok {
+		return Destination{}, fmt.Errorf("unknown destination kind %q", kind)
+	}
+	return Destination{Name: name, Ack: ack, Headers: headers, Decode: k.Decode, Handle: k.Handle, chat: kind == "chat"}, nil
+}
+
+// loopsBack reports whether a message published to d comes back to the
+// hub and is routed to its recipients: d carries chat events and has no
+// selector that could filter the message out.
+func (d Destination) loopsBack() bool {
+	if _, filtered := d.Headers["selector"]; filtered {
+		return false
+	}
+	return d.chat || d.Decode == nil && d.Handle == nil
+}
+
+func (d Destination) subscribeOpts() []func(*frame.Frame) error {
+	keys := make([]string, 0, len(d.Headers))
+	for k := range d.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	opts := make([]func(*frame.Frame) error, 0, len(keys))
+	for _, k := range keys {
+		opts = append(opts, stomp.SubscribeOpt.Header(k, d.Headers[k]))
+	}
+	return opts
+}
+
+// destinationHandler acks a frame once its messages were accepted (see
+// DeliveryReport and RouteMessages). Failed deliveries are NACKed so the
+// broker redelivers them; recipients that did get the first attempt may see
+// the message again and should dedupe by ID. In auto mode the broker considers
+// the frame delivered as soon as it is sent, so failures are only logged.
+func (h *Hub) destinationHandler(d Destination) func(*stomp.Message) {
+	decode, handle := d.Decode, d.Handle
+	if decode == nil {
+		decode = DecodeMessage
+	}
+	if handle == nil {
+		handle = RouteMessages
+	}
+	maxRedeliveries := d.MaxRedeliveries
+	if maxRedeliveries <= 0 {
+		maxRedeliveries = DefaultMaxRedeliveries
+	}
+	failures := newRedeliveries(d.Name, d.RewritesMessageIDs)
+
+	return func(msg *stomp.Message) {
+		msgs, err := decode(msg)
+		if err != nil {
+			h.deadLetter(d, msg, fmt.Errorf("undecodable frame: %w", err))
+			return
+		}
+		err = handle(h, msgs)
+		if d.Ack == stomp.AckAuto {
+			if err != nil {
+				log.Printf("STOMP %s: %v", d.Name, err)
+			}
+			return
+		}
+
+		if errors.Is(err, errHubStopped) {
+			// Unacked frames go to another consumer once we unsubscribe.
+			log.Printf("STOMP %s: hub stopping, leaving frame for redelivery", d.Name)
+			return
+		}
+		key := failures.key(msg)
+		if err == nil {
+			failures.forget(key)
+			if err := msg.Conn.Ack(msg); err != nil {
+				log.Printf("STOMP %s: ack failed: %v", d.Name, err)
+			}
+			return
+		}
+
+		attempt := failures.fail(key)
+		if attempt > maxRedeliveries {
+			failures.forget(key)
+			h.deadLetter(d, msg, fmt.Errorf("gave up after %d attempts: %w", attempt, err))
+			return
+		}
+		log.Printf("STOMP %s: delivery failed (attempt %d), requesting redelivery: %v", d.Name, attempt, err)
+		select {
+		case <-time.After(min(time.Duration(attempt)*redeliveryBackoff, 2*time.Second)):
+		case <-h.done:
+		}
+		if err := msg.Conn.Nack(msg); err != nil {
+			log.Printf("STOMP %s: nack failed: %v", d.Name, err)
+		}
+	}
+}
+
+// deadLetter moves a poison frame to d.DeadLetter and acks the original.
+// When the dead-letter send fails the frame is NACKed instead so it is not
+// lost.
+func (h *Hub) deadLetter(d Destination, msg *stomp.Message, reason error) {
+	settle := func(ok bool) {
+		if d.Ack == stomp.AckAuto {
+			return
+		}
+		var err error
+		if ok {
+			err = msg.Conn.Ack(msg)
+		} else {
+			err = msg.Conn.Nack(msg)
+		}
+		if err != nil {
+			log.Printf("STOMP %s: settle failed: %v", d.Name, err)
+		}
+	}
+
+	if d.DeadLetter == "" {
+		log.Printf("STOMP %s: dropping poison frame: %v", d.Name, reason)
+		settle(true)
+		return
+	}
+	b := h.stompBroker()
+	if b == nil {
+		settle(false)
+		return
+	}
+	err := b.send(d.DeadLetter, msg.ContentType, msg.Body,
+		stomp.SendOpt.Header("x-original-destination", d.Name),
+		stomp.SendOpt.Header("x-original-message-id", msg.Header.Get(frame.MessageId)),
+		stomp.SendOpt.Header("x-dead-letter-reason", reason.Error()),
+	)
+	if err != nil {
+		log.Printf("STOMP %s: dead-letter to %s failed: %v", d.Name, d.DeadLetter, err)
+		settle(false)
+		return
+	}
+	log.Printf("STOMP %s: dead-lettered to %s: %v", d.Name, d.DeadLetter, reason)
+	settle(true)
+}
+
+// redeliveries counts failed attempts per frame of one destination. Frames
+// are keyed by message-id, or by body when the broker rewrites IDs or sent
+// none; identical bodies then share a counter.
+type redeliveries struct {
+	dest   string
+	byBody bool
+
+	mu    sync.Mutex
+	count map[[sha256.Size]byte]int
+}
+
+func newRedeliveries(dest string, byBody bool) *redeliveries {
+	return &redeliveries{dest: dest, byBody: byBody, count: make(map[[sha256.Size]byte]int)}
+}
+
+func (r *redeliveries) key(msg *stomp.Message) [sha256.Size]byte {
+	id := msg.Header.Get(frame.MessageId)
+	if r.byBody || id == "" {
+		return sha256.Sum256(append([]byte("body\x00"+r.dest+"\x00"), msg.Body...))
+	}
+	return sha256.Sum256([]byte("id\x00" + r.dest + "\x00" + id))
+}
+
+func (r *redeliveries) fail(key [sha256.Size]byte) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.count) >= redeliveryTrackMax {
+		// Entries of frames that were dropped elsewhere never get
+		// forgotten; start over rather than grow without bound.
+		r.count = make(map[[sha256.Size]byte]int)
+	}
+	r.count[key]++
+	return r.count[key]
+}
+
+func (r *redeliveries) forget(key [sha256.Size]byte) {
+	r.mu.Lock()
+	delete(r.count, key)
+	r.mu.Unlock()
+}
+
+// DecodeMessage reads a JSON models.Message.
+func DecodeMessage(msg *stomp.Message) ([]*models.Message, error) {
+	var m models.Message
+	if err := json.Unmarshal(msg.Body, &m); err != nil {
+		return nil, err
+	}
+	return []*models.Message{&m}, nil
+}
+
+// DecodePresence reads a JSON models.PresenceStatus published by the
+// presence service and turns it into a presence event.
+func DecodePresence(msg *stomp.Message) ([]*models.Message, error) {
+	var st models.PresenceStatus
+	if err := json.Unmarshal(msg.Body, &st); err != nil {
+		return nil, err
+	}
+	if st.UserID == "" {
+		return nil, fmt.Errorf("presence without user_id")
+	}
+	eventType := models.EventTypePresenceOffline
+	if st.Online {
+		eventType = models.EventTypePresenceOnline
+	}
+	return []*models.Message{{SenderID: st.UserID, EventType: eventType}}, nil
+}
+
+// DecodeSystemNotice reads a JSON models.Message and forces its event type.
+func DecodeSystemNotice(msg *stomp.Message) ([]*models.Message, error) {
+	msgs, err := DecodeMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	msgs[0].EventType = models.EventTypeSystemNotice
+	return msgs, nil
+}
+
+// RouteMessages delivers chat events to their recipients and fails when a
+// message reached none of them. A message some recipients accepted is not
+// redelivered for the others: a NACK would hand it again to every
+// recipient because of one slow socket.
+func RouteMessages(h *Hub, msgs []*models.Message) error {
+	var errs []error
+	for _, m := range msgs {
+		if err := settleDelivery(h.Deliver(m)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RoutePresence notifies local watchers of each user.
+func RoutePresence(h *Hub, msgs []*models.Message) error {
+	for _, m := range msgs {
+		h.notifyWatchers(m.SenderID, m.EventType)
+	}
+	return nil
+}
+
+// RouteSystemNotices sends addressed notices to their recipients and
+// unaddressed ones to every local socket. Publish broadcast notices on a
+// topic so every instance receives them.
+func RouteSystemNotices(h *Hub, msgs []*models.Message) error {
+	var addressed []*models.Message
+	for _, m := range msgs {
+		if m.RecipientID != "" || m.GroupID != "" {
+			addressed = append(addressed, m)
+			continue
+		}
+		if err := settleDelivery(h.deliverAll(m)); err != nil {
+			return err
+		}
+	}
+	return RouteMessages(h, addressed)
+}
+
+// settleDelivery drops ErrDeliveryIncomplete when the message reached at
+// least one recipient.
+func settleDelivery(r DeliveryReport, err error) error {
+	if errors.Is(err, ErrDeliveryIncomplete) && r.Sockets+r.RemoteNodes+r.Queued > 0 {
+		log.Printf("Message reached only some recipients (%d sockets dropped it); not redelivering", r.Dropped)
+		return nil
+	}
+	return err
+}
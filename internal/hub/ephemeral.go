@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gofrs/uuid"
+)
+
+// ======================
+// Ephemeral Events
+// ======================
+
+// Typing indicators and read receipts are best-effort signals: they go to
+// the other participants only, are throttled per sender and are never
+// published to the broker or queued for later delivery.
+
+const (
+	typingTimeout        = 6 * time.Second // typing.stop is synthesized after this
+	ephemeralMinInterval = time.Second     // per sender, conversation and event type
+	ephemeralSweepSize   = 10000
+)
+
+var ErrRateLimited = errors.New("event rate limited")
+
+type ephemeralTracker struct {
+	mu     sync.Mutex
+	last   map[string]time.Time   // throttle key → last accepted
+	typing map[string]*time.Timer // typing key → expiry
+}
+
+func newEphemeralTracker() *ephemeralTracker {
+	return &ephemeralTracker{
+		last:   make(map[string]time.Time),
+		typing: make(map[string]*time.Timer),
+	}
+}
+
+// conversationKey identifies where an ephemeral event happens from the
+// sender's point of view.
+func conversationKey(msg *models.Message) string {
+	if msg.GroupID != "" {
+		return msg.SenderID + "|g:" + msg.GroupID
+	}
+	return msg.SenderID + "|u:" + msg.RecipientID
+}
+
+// routeEphemeral throttles and forwards a typing or read event.
+func (h *Hub) routeEphemeral(msg *models.Message) error {
+	if err := h.ThrottleEphemeral(msg); err != nil {
+		return err
+	}
+	h.Broadcast(msg)
+	return nil
+}
+
+// ThrottleEphemeral applies the per-sender throttle to a typing or read
+// event and returns ErrRateLimited when it must be dropped. Callers that
+// deliver ephemeral events themselves use it instead of HandleInbound. A
+// throttled typing.start still extends the indicator's lifetime.
+func (h *Hub) ThrottleEphemeral(msg *models.Message) error {
+	t := h.ephemeral
+	key := conversationKey(msg)
+
+	t.mu.Lock()
+	switch msg.EventType {
+	case models.EventTypeTyping:
+		h.armTypingLocked(key, msg)
+	case models.EventTypeStopTyping:
+		if timer, ok := t.typing[key]; ok {
+			timer.Stop()
+			delete(t.typing, key)
+		}
+	}
+	allowed := msg.EventType == models.EventTypeStopTyping || t.allowLocked(key+"|"+msg.EventType)
+	t.mu.Unlock()
+
+	if !allowed {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// armTypingLocked (re)starts the timer that ends a typing indicator the
+// client never stopped. Callers must hold h.ephemeral.mu.
+func (h *Hub) armTypingLocked(key string, start *models.Message) {
+	t := h.ephemeral
+	if timer, ok := t.typing[key]; ok {
+		timer.Reset(typingTimeout)
+		return
+	}
+	stop := *start
+	t.typing[key] = time.AfterFunc(typingTimeout, func() {
+		t.mu.Lock()
+		delete(t.typing, key)
+		t.mu.Unlock()
+
+		id, _ := uuid.NewV4()
+		stop.ID = id.String()
+		stop.EventType = models.EventTypeStopTyping
+		stop.Content = ""
+		stop.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+		h.Broadcast(&stop)
+	})
+}
+
+// allowLocked applies the per-key minimum interval. Callers must hold t.mu.
+func (t *ephemeralTracker) allowLocked(key string) bool {
+	now := time.Now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < ephemeralMinInterval {
+		return false
+	}
+	if len(t.last) >= ephemeralSweepSize {
+		for k, at := range t.last {
+			if now.Sub(at) >= ephemeralMinInterval {
+				delete(t.last, k)
+			}
+		}
+	}
+	t.last[key] = now
+	return true
+}
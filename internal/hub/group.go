@@ -0,0 +1,339 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"sort"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gofrs/uuid"
+)
+
+// ======================
+// Group & Room Membership
+// ======================
+
+// Groups created through the API get a server-generated ID and an owner.
+// Members invite users, who join by adding themselves; nobody joins a group
+// without an invitation or is added without consent. Members may leave, and
+// the owner may remove anyone. JoinGroup and LeaveGroup bypass these rules
+// for trusted sources of membership such as the broker.
+
+var (
+	ErrNotInvited    = errors.New("not invited to the group")
+	ErrNotGroupOwner = errors.New("only the group owner may remove other members")
+)
+
+// CreateGroup starts a group owned by ownerID, its first member, and
+// invites userIDs. It returns the new group's ID and the users invited.
+func (h *Hub) CreateGroup(ownerID string, userIDs ...string) (groupID string, invited []string) {
+	id, _ := uuid.NewV4()
+	groupID = id.String()
+	h.createGroup(groupID, ownerID)
+	h.cluster.publishGroupEvent(groupEvent{Group: groupID, Owner: ownerID, Users: []string{ownerID}, Join: true})
+	if invited = h.inviteGroup(groupID, userIDs); len(invited) > 0 {
+		h.cluster.publishGroupEvent(groupEvent{Group: groupID, Users: invited, Invite: true})
+	}
+	return groupID, invited
+}
+
+func (h *Hub) createGroup(groupID, ownerID string) {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	h.owners[groupID] = ownerID
+	if h.groups[groupID] == nil {
+		h.groups[groupID] = make(map[string]struct{})
+	}
+	h.groups[groupID][ownerID] = struct{}{}
+}
+
+// AddGroupMembers applies callerID's request to add userIDs: the caller
+// joins when invited, and the others are invited when the caller is a
+// member. The check and the change happen under one lock. It returns the
+// users who joined and those newly invited.
+func (h *Hub) AddGroupMembers(groupID, callerID string, userIDs []string) (joined, invited []string, err error) {
+	h.groupMu.Lock()
+	members := h.groups[groupID]
+	_, member := members[callerID]
+	for _, uid := range userIDs {
+		if _, ok := members[uid]; ok || uid == "" {
+			continue
+		}
+		switch {
+		case uid == callerID:
+			if _, ok := h.invites[groupID][uid]; !ok {
+				h.groupMu.Unlock()
+				return nil, nil, ErrNotInvited
+			}
+			joined = append(joined, uid)
+		case !member:
+			h.groupMu.Unlock()
+			return nil, nil, ErrNotGroupMember
+		default:
+			invited = append(invited, uid)
+		}
+	}
+	for _, uid := range joined {
+		members[uid] = struct{}{}
+		delete(h.invites[groupID], uid)
+	}
+	invited = h.inviteLocked(groupID, invited)
+	h.groupMu.Unlock()
+
+	if len(joined) > 0 {
+		h.cluster.publishGroup(groupID, joined, true)
+	}
+	if len(invited) > 0 {
+		h.cluster.publishGroupEvent(groupEvent{Group: groupID, Users: invited, Invite: true})
+	}
+	return joined, invited, nil
+}
+
+// RemoveGroupMembers applies callerID's request to remove userIDs: members
+// may remove themselves, the owner anyone.
+func (h *Hub) RemoveGroupMembers(groupID, callerID string, userIDs []string) error {
+	h.groupMu.Lock()
+	owner := h.owners[groupID] == callerID
+	for _, uid := range userIDs {
+		if uid != callerID && !owner {
+			h.groupMu.Unlock()
+			return ErrNotGroupOwner
+		}
+	}
+	h.leaveGroupLocked(groupID, userIDs)
+	h.groupMu.Unlock()
+
+	h.cluster.publishGroup(groupID, userIDs, false)
+	return nil
+}
+
+// GroupOwner returns the owner of a group created through CreateGroup.
+func (h *Hub) GroupOwner(groupID string) string {
+	h.groupMu.RLock()
+	defer h.groupMu.RUnlock()
+	return h.owners[groupID]
+}
+
+func (h *Hub) inviteGroup(groupID string, userIDs []string) []string {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	return h.inviteLocked(groupID, userIDs)
+}
+
+// inviteLocked records invitations to an existing group and returns the
+// users newly invited. Callers must hold h.groupMu.
+func (h *Hub) inviteLocked(groupID string, userIDs []string) []string {
+	members, ok := h.groups[groupID]
+	if !ok {
+		return nil
+	}
+	var out []string
+	for _, uid := range userIDs {
+		if _, member := members[uid]; member || uid == "" {
+			continue
+		}
+		if h.invites[groupID] == nil {
+			h.invites[groupID] = make(map[string]struct{})
+		}
+		if _, ok := h.invites[groupID][uid]; !ok {
+			h.invites[groupID][uid] = struct{}{}
+			out = append(out, uid)
+		}
+	}
+	return out
+}
+
+// JoinGroup adds users to a group so messages carrying that GroupID
+// reach all of their sockets. Membership is replicated to cluster peers.
+func (h *Hub) JoinGroup(groupID string, userIDs ...string) {
+	h.joinGroup(groupID, userIDs)
+	h.cluster.publishGroup(groupID, userIDs, true)
+}
+
+func (h *Hub) joinGroup(groupID string, userIDs []string) {
+	if groupID == "" {
+		return
+	}
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+
+	members, ok := h.groups[groupID]
+	if !ok {
+		members = make(map[string]struct{})
+		h.groups[groupID] = members
+	}
+	for _, uid := range userIDs {
+		if uid != "" {
+			members[uid] = struct{}{}
+		}
+	}
+	log.Printf("Group %s: %d members", groupID, len(members))
+}
+
+// LeaveGroup removes users from a group. The group is dropped once empty.
+func (h *Hub) LeaveGroup(groupID string, userIDs ...string) {
+	h.leaveGroup(groupID, userIDs)
+	h.cluster.publishGroup(groupID, userIDs, false)
+}
+
+func (h *Hub) leaveGroup(groupID string, userIDs []string) {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	h.leaveGroupLocked(groupID, userIDs)
+}
+
+// leaveGroupLocked removes members and drops the group, its owner and its
+// invitations once empty. Callers must hold h.groupMu.
+func (h *Hub) leaveGroupLocked(groupID string, userIDs []string) {
+	members, ok := h.groups[groupID]
+	if !ok {
+		return
+	}
+	for _, uid := range userIDs {
+		delete(members, uid)
+	}
+	if len(members) == 0 {
+		h.dropGroupLocked(groupID)
+	}
+}
+
+func (h *Hub) dropGroupLocked(groupID string) {
+	delete(h.groups, groupID)
+	delete(h.owners, groupID)
+	delete(h.invites, groupID)
+}
+
+// setGroup replaces the members and owner of a group, as seen in a peer's
+// snapshot.
+func (h *Hub) setGroup(groupID, ownerID string, userIDs []string) {
+	if groupID == "" {
+		return
+	}
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+
+	members := make(map[string]struct{}, len(userIDs))
+	for _, uid := range userIDs {
+		if uid != "" {
+			members[uid] = struct{}{}
+		}
+	}
+	if len(members) == 0 {
+		h.dropGroupLocked(groupID)
+		return
+	}
+	h.groups[groupID] = members
+	if ownerID != "" {
+		h.owners[groupID] = ownerID
+	}
+}
+
+// GroupMembers returns the sorted user IDs of a group.
+func (h *Hub) GroupMembers(groupID string) []string {
+	h.groupMu.RLock()
+	defer h.groupMu.RUnlock()
+
+	out := make([]string, 0, len(h.groups[groupID]))
+	for uid := range h.groups[groupID] {
+		out = append(out, uid)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// IsGroupMember reports whether userID belongs to groupID.
+func (h *Hub) IsGroupMember(groupID, userID string) bool {
+	h.groupMu.RLock()
+	defer h.groupMu.RUnlock()
+	_, ok := h.groups[groupID][userID]
+	return ok
+}
+
+// JoinRoom attaches a single socket to a room, leaving its previous room.
+// A room records which group a connection has open; only members may open
+// one, and delivery does not depend on it since members get every group
+// message on all their sockets.
+func (h *Hub) JoinRoom(c *Client, roomID string) error {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	h.leaveRoomLocked(c)
+	if roomID == "" {
+		return nil
+	}
+	if _, ok := h.groups[roomID][c.UserID]; !ok {
+		return ErrNotGroupMember
+	}
+	h.joinRoomLocked(c, roomID)
+	return nil
+}
+
+// LeaveRoom detaches a socket from its current room.
+func (h *Hub) LeaveRoom(c *Client) {
+	h.groupMu.Lock()
+	defer h.groupMu.Unlock()
+	h.leaveRoomLocked(c)
+}
+
+// RoomClients returns a copy of the sockets currently in a room.
+func (h *Hub) RoomClients(roomID string) []*Client {
+	h.groupMu.RLock()
+	defer h.groupMu.RUnlock()
+
+	out := make([]*Client, 0, len(h.rooms[roomID]))
+	for c := range h.rooms[roomID] {
+		out = append(out, c)
+	}
+	return out
+}
+
+func (h *Hub) joinRoomLocked(c *Client, roomID string) {
+	room, ok := h.rooms[roomID]
+	if !ok {
+		room = make(map[*Client]struct{})
+		h.rooms[roomID] = room
+	}
+	room[c] = struct{}{}
+	c.RoomID = roomID
+}
+
+func (h *Hub) leaveRoomLocked(c *Client) {
+	if c.RoomID == "" {
+		return
+	}
+	if room, ok := h.rooms[c.RoomID]; ok {
+		delete(room, c)
+		if len(room) == 0 {
+			delete(h.rooms, c.RoomID)
+		}
+	}
+	c.RoomID = ""
+}
+
+// recipientUsers lists the users a message is addressed to: the direct
+// recipient plus group members, without the sender for ephemeral events.
+func (h *Hub) recipientUsers(msg *models.Message) []string {
+	var out []string
+	if msg.RecipientID != "" {
+		out = append(out, msg.RecipientID)
+	}
+	if msg.GroupID != "" {
+		h.groupMu.RLock()
+		for uid := range h.groups[msg.GroupID] {
+			if uid != msg.RecipientID {
+				out = append(out, uid)
+			}
+		}
+		h.groupMu.RUnlock()
+	}
+	if msg.Ephemeral() {
+		kept := out[:0]
+		for _, uid := range out {
+			if uid != msg.SenderID {
+				kept = append(kept, uid)
+			}
+		}
+		out = kept
+	}
+	return out
+}
@@ -3,12 +3,10 @@ package hub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
-	"net/http"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"go-gin-example/internal/models" // adjust path
@@ -27,6 +25,40 @@ type Client struct {
 	RoomID string
 	Conn   *websocket.Conn
 	Send   chan []byte
+	Codec  Codec   // nil → raw JSON
+	Resume *Cursor // set before registering to replay from a position
+	Device DeviceInfo
+
+	SlowPolicy SlowConsumerPolicy // what to do when Send is full; empty drops
+	TokenID    string             // jti of the token the socket authenticated with
+
+	hub      *Hub
+	spilling atomic.Bool // frames are parked in the offline store
+	slowOnce sync.Once
+
+	// Send is never closed; quit ends the write pump instead (see release).
+	init       sync.Once
+	quit       chan struct{}
+	quitOnce   sync.Once
+	closeFrame []byte    // written after the flush, nil for none
+	drainBy    time.Time // flush buffered frames until then
+	pumping    atomic.Bool
+	pumpDone   chan struct{}
+}
+
+// Codec adapts hub deliveries to a client's wire protocol (e.g. STOMP
+// MESSAGE frames). Encode returns false when the client has no interest in
+// the message, for instance because it has no matching subscription.
+type Codec interface {
+	Encode(msg *models.Message, data []byte) ([]byte, bool)
+}
+
+// frame renders a delivery for this client.
+func (c *Client) frame(msg *models.Message, data []byte) ([]byte, bool) {
+	if c.Codec == nil {
+		return data, true
+	}
+	return c.Codec.Encode(msg, data)
 }
 
 // ======================
@@ -34,18 +66,89 @@ type Client struct {
 // ======================
 
 type Hub struct {
-	clients map[string][]*Client // userID → []Client
-	mu      sync.RWMutex
+	shards []*shard // sockets, partitioned by user (see shard.go)
+
+	// mu guards the settings below (stores, cluster, broker). Operations
+	// hold it for reading, so a setter waits for in-flight deliveries.
+	mu sync.RWMutex
+
+	groups  map[string]map[string]struct{}  // groupID → userIDs
+	owners  map[string]string               // groupID → owner, for groups created through the API
+	invites map[string]map[string]struct{}  // groupID → invited userIDs
+	rooms   map[string]map[*Client]struct{} // roomID → clients
+	groupMu sync.RWMutex
+
+	ephemeral *ephemeralTracker
+	presence  *presenceTracker
+	cluster   *cluster     // nil when standalone
+	offline   OfflineStore // nil disables offline queueing
+	seqs      *sequencer
+	slow      *slowStats
+
+	broker     *broker // nil until InitSTOMP
+	publishing PublishConfig
+	loopback   map[string]bool     // destinations whose frames come back as chat
+	outbound   chan inboundPublish // client messages waiting to be published
+
+	started   bool
+	draining  atomic.Bool
+	stopOnce  sync.Once
+	done      chan struct{} // closed when the hub stops
+	stopped   chan struct{} // closed once the loops exited and sockets are closed
+	closeOnce sync.Once
+}
 
-	Register   chan *Client
-	Unregister chan *Client
-	Broadcast  chan *models.Message
+// Options configures a hub built by New. Zero values pick the defaults.
+type Options struct {
+	Shards int // DefaultShards when 0
 
-	stompConn *stomp.Conn
-	sub       *stomp.Subscription
+	Offline        OfflineStore // in-memory store with the defaults when nil
+	DisableOffline bool         // no offline queue at all
 
-	done      chan struct{}
-	closeOnce sync.Once
+	ResumeBuffer int           // DefaultResumeBuffer when 0
+	ResumeTTL    time.Duration // DefaultResumeTTL when 0
+
+	SlowConsumer map[string]SlowConsumerPolicy // endpoint → policy; unset endpoints drop
+}
+
+// New builds a hub. Nothing runs until Start.
+func New(opts Options) *Hub {
+	n := opts.Shards
+	if n < 1 {
+		n = DefaultShards
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = newShard()
+	}
+
+	offline := opts.Offline
+	if opts.DisableOffline {
+		offline = nil
+	} else if offline == nil {
+		offline = NewMemoryOfflineStore(DefaultOfflineMaxPerUser, DefaultOfflineTTL)
+	}
+
+	h := &Hub{
+		shards:    shards,
+		groups:    make(map[string]map[string]struct{}),
+		owners:    make(map[string]string),
+		invites:   make(map[string]map[string]struct{}),
+		rooms:     make(map[string]map[*Client]struct{}),
+		ephemeral: newEphemeralTracker(),
+		presence:  newPresenceTracker(),
+		offline:   offline,
+		seqs:      newSequencer(opts.ResumeBuffer, opts.ResumeTTL),
+		slow:      newSlowStats(),
+		loopback:  make(map[string]bool),
+		outbound:  make(chan inboundPublish, publishQueueSize),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	for endpoint, p := range opts.SlowConsumer {
+		h.slow.policies[endpoint] = p
+	}
+	return h
 }
 
 var (
@@ -53,186 +156,323 @@ var (
 	once      sync.Once
 )
 
+// Get returns a process-wide hub, started on first use.
+//
+// Deprecated: build a hub with New and pass it to its users.
 func Get() *Hub {
 	once.Do(func() {
-		globalHub = &Hub{
-			clients:    make(map[string][]*Client),
-			Register:   make(chan *Client, 256),
-			Unregister: make(chan *Client, 256),
-			Broadcast:  make(chan *models.Message, 1024),
-			done:       make(chan struct{}),
-		}
-		go globalHub.Run(context.Background())
+		globalHub = New(Options{})
+		globalHub.Start(context.Background())
 	})
 	return globalHub
 }
 
+var ErrHubStarted = errors.New("hub already started")
+
+// Start runs the hub in the background until Stop is called. Cancelling
+// ctx stops it abruptly, without draining sockets.
+func (h *Hub) Start(ctx context.Context) error {
+	if err := h.begin(); err != nil {
+		return err
+	}
+	go h.run(ctx)
+	return nil
+}
+
+func (h *Hub) begin() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started {
+		return ErrHubStarted
+	}
+	h.started = true
+	return nil
+}
+
 // ======================
 // 3. Run Loop
 // ======================
 
+// Run serves the hub in the foreground; see Start.
 func (h *Hub) Run(ctx context.Context) {
-	log.Println("Hub started")
-	for {
-		select {
-		case <-ctx.Done():
-			h.cleanup()
-			return
-		case c := <-h.Register:
-			h.registerClient(c)
-		case c := <-h.Unregister:
-			h.unregisterClient(c)
-		case msg := <-h.Broadcast:
-			h.broadcastMessage(msg)
-		case <-h.done:
-			h.cleanup()
-			return
-		}
+	if err := h.begin(); err != nil {
+		log.Printf("Hub: %v", err)
+		return
 	}
+	h.run(ctx)
+}
+
+// run serves every shard's loop until ctx is cancelled or the hub stops.
+func (h *Hub) run(ctx context.Context) {
+	defer close(h.stopped)
+	log.Printf("Hub started with %d shards", len(h.shards))
+	go h.runPublisher(ctx)
+	var wg sync.WaitGroup
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func(s *shard) {
+			defer wg.Done()
+			h.runShard(ctx, s)
+		}(s)
+	}
+	wg.Wait()
+	h.closeOnce.Do(func() { close(h.done) })
+	h.cleanup()
 }
 
 func (h *Hub) registerClient(c *Client) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	if _, ok := h.clients[c.UserID]; !ok {
-		h.clients[c.UserID] = make([]*Client, 0)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := h.shardFor(c.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if h.Draining() {
+		c.release(goingAway(), time.Now())
+		return
+	}
+	if c.released() {
+		return // unregistered before its registration was processed
+	}
+	c.hub = h
+	s.clients[c.UserID] = append(s.clients[c.UserID], c)
+	if c.RoomID != "" {
+		if err := h.JoinRoom(c, c.RoomID); err != nil {
+			log.Printf("Room %s refused for %s: %v", c.RoomID, c.ID, err)
+		}
 	}
-	h.clients[c.UserID] = append(h.clients[c.UserID], c)
+	h.presenceConnected(c)
+	h.catchUpLocked(c) // codec clients catch up again once subscribed
 	log.Printf("Registered: %s (UserID=%s)", c.ID, c.UserID)
 }
 
 func (h *Hub) unregisterClient(c *Client) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := h.shardFor(c.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if list, ok := h.clients[c.UserID]; ok {
+	if list, ok := s.clients[c.UserID]; ok {
 		for i, cl := range list {
-			if cl.ID == c.ID {
-				h.clients[c.UserID] = append(list[:i], list[i+1:]...)
+			if cl == c {
+				s.clients[c.UserID] = append(list[:i], list[i+1:]...)
 				break
 			}
 		}
-		if len(h.clients[c.UserID]) == 0 {
-			delete(h.clients, c.UserID)
+		if len(s.clients[c.UserID]) == 0 {
+			delete(s.clients, c.UserID)
 		}
 	}
+	h.LeaveRoom(c)
+	h.presenceDisconnected(c)
+	h.dropSpillLocked(c)
+	c.release(nil, time.Now().Add(unregisterDrain))
+	log.Printf("Unregistered: %s (UserID=%s)", c.ID, c.UserID)
+}
+
+func (h *Hub) broadcastMessage(msg *models.Message) {
+	h.Deliver(msg)
+}
+
+// DeliveryReport says how far a message got. A message is accepted once it
+// sits in the Send buffer of every targeted local socket, in the cluster
+// outbox for every remote node holding a recipient and in the offline store
+// for every recipient without a socket.
+type DeliveryReport struct {
+	Sockets     int `json:"sockets"`      // local sockets the message was queued, spilled or kept for replay on
+	Dropped     int `json:"dropped"`      // local sockets whose slow-consumer policy lost it
+	RemoteNodes int `json:"remote_nodes"` // cluster nodes it was forwarded to
+	Queued      int `json:"queued"`       // offline recipients it was stored for
+}
+
+var ErrDeliveryIncomplete = errors.New("message not accepted by every recipient")
+
+// Deliver routes msg synchronously, bypassing the Broadcast queue, and
+// reports whether every recipient accepted it.
+func (h *Hub) Deliver(msg *models.Message) (DeliveryReport, error) {
+	if h.Draining() {
+		return DeliveryReport{}, errHubStopped
+	}
 	select {
-	case <-c.Send:
+	case <-h.done:
+		return DeliveryReport{}, errHubStopped
 	default:
-		close(c.Send)
 	}
-	log.Printf("Unregistered: %s (UserID=%s)", c.ID, c.UserID)
+
+	e := encode(msg)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := h.recipientUsers(msg)
+	r, stored := h.deliverShardsLocked(e, users, true)
+	nodes, forwarded := h.cluster.forward(msg, users)
+	r.RemoteNodes = nodes
+	if r.Dropped > 0 || !forwarded || !stored {
+		return r, ErrDeliveryIncomplete
+	}
+	return r, nil
 }
 
-func (h *Hub) broadcastMessage(msg *models.Message) {
+// deliverToUsers delivers a message forwarded by another node to the local
+// sockets of the given users only.
+func (h *Hub) deliverToUsers(msg *models.Message, userIDs []string) {
+	e := encode(msg)
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	recipients := make(map[string]bool)
-	if msg.RecipientID != "" {
-		recipients[msg.RecipientID] = true
-	}
-	// Add group logic here if needed
-
-	data, _ := json.Marshal(msg)
-	sent := 0
-	for uid := range recipients {
-		for _, c := range h.clients[uid] {
-			select {
-			case c.Send <- data:
-				sent++
-			default:
-				log.Printf("Buffer full: dropping msg for %s", uid)
-			}
+	users := make([]string, 0, len(userIDs))
+	for _, uid := range userIDs {
+		if !msg.Ephemeral() || uid != msg.SenderID {
+			users = append(users, uid)
 		}
 	}
-	log.Printf("Sent to %d clients", sent)
+	h.deliverShardsLocked(e, users, false)
+}
+
+type sendResult int
+
+const (
+	sendQueued  sendResult = iota // in the buffer, spilled or kept for replay
+	sendDropped                   // lost to the slow-consumer policy
+	sendSkipped                   // the client's codec has no interest
+)
+
+// sendLocked queues one user's copy of a message on c without blocking.
+// Callers must hold c's shard lock.
+func (h *Hub) sendLocked(c *Client, m *models.Message, data []byte) sendResult {
+	if c.released() {
+		return sendDropped
+	}
+	payload, ok := c.frame(m, data)
+	if !ok {
+		return sendSkipped
+	}
+	// A spilling socket keeps spilling until drained so frames stay in order.
+	if c.spilling.Load() && !m.Ephemeral() && h.offline != nil && h.spill(c, m) {
+		return sendQueued
+	}
+	select {
+	case c.Send <- payload:
+		return sendQueued
+	default:
+	}
+	if h.overflow(c, m, payload) {
+		return sendQueued
+	}
+	return sendDropped
 }
 
 // ======================
 // 4. STOMP Integration
 // ======================
 
-func (h *Hub) InitSTOMP(broker, dest string, ack stomp.AckMode) error {
-	conn, err := stomp.Dial("tcp", broker,
-		stomp.ConnOpt.HeartBeat(10*time.Second, 10*time.Second),
-	)
-	if err != nil {
-		return err
+// InitSTOMP starts the broker supervisor with a single chat destination.
+// See StartSTOMP.
+func (h *Hub) InitSTOMP(addr, dest string, ack stomp.AckMode, opts ...func(*stomp.Conn) error) error {
+	return h.StartSTOMP(addr, []Destination{{Name: dest, Ack: ack}}, opts...)
+}
+
+// StartSTOMP starts the broker supervisor and subscribes every destination.
+// It returns the result of the first connection attempt; on failure the
+// supervisor keeps retrying in the background until StopSTOMP is called.
+func (h *Hub) StartSTOMP(addr string, dests []Destination, opts ...func(*stomp.Conn) error) error {
+	b := newBroker(addr, opts...)
+	for _, d := range dests {
+		b.subscribe(d.Name, d.Ack, h.destinationHandler(d), d.subscribeOpts()...)
 	}
-	sub, err := conn.Subscribe(dest, ack)
-	if err != nil {
-		conn.Disconnect()
-		return err
+
+	h.mu.Lock()
+	h.broker = b
+	for _, d := range dests {
+		h.loopback[d.Name] = h.loopback[d.Name] || d.loopsBack()
 	}
-	h.stompConn = conn
-	h.sub = sub
-	go h.stompForwarder(ack)
-	go h.handleSignals()
-	return nil
+	h.mu.Unlock()
+
+	go b.supervise()
+	return <-b.ready
 }
 
-func (h *Hub) stompForwarder(ack stomp.AckMode) {
-	for {
-		select {
-		case <-h.done:
-			return
-		case msg, ok := <-h.sub.C:
-			if !ok {
-				return
-			}
-			if ack != stomp.AckAuto {
-				_ = h.stompConn.Ack(msg)
-			}
-			var chatMsg models.Message
-			if json.Unmarshal(msg.Body, &chatMsg) != nil {
-				continue
-			}
-			select {
-			case h.Broadcast <- &chatMsg:
-			case <-h.done:
-				return
-			}
-		}
-	}
+// SubscribeSTOMP forwards another chat destination to sockets.
+func (h *Hub) SubscribeSTOMP(dest string, ack stomp.AckMode) error {
+	return h.SubscribeDestination(Destination{Name: dest, Ack: ack})
 }
 
-// ======================
-// 5. WebSocket Handler
-// ======================
+// SubscribeDestination adds a destination after StartSTOMP. Like the
+// initial ones it survives reconnects.
+func (h *Hub) SubscribeDestination(d Destination) error {
+	b := h.stompBroker()
+	if b == nil {
+		return errors.New("STOMP is not initialised")
+	}
+	b.subscribe(d.Name, d.Ack, h.destinationHandler(d), d.subscribeOpts()...)
+	h.mu.Lock()
+	h.loopback[d.Name] = h.loopback[d.Name] || d.loopsBack()
+	h.mu.Unlock()
+	return nil
+}
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// StopSTOMP stops the supervisor and disconnects from the broker.
+func (h *Hub) StopSTOMP() {
+	if b := h.stompBroker(); b != nil {
+		b.stop()
+	}
 }
 
-func validateToken(token string) (string, error) {
-	// TODO: real JWT parse
-	if token == "valid-token" {
-		return "user123", nil
+// BrokerHealth reports the broker connection state for health checks.
+func (h *Hub) BrokerHealth() BrokerHealth {
+	b := h.stompBroker()
+	if b == nil {
+		return BrokerHealth{State: BrokerDisabled}
 	}
-	return "", http.ErrNoCookie
+	return b.healthSnapshot()
+}
+
+func (h *Hub) stompBroker() *broker {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.broker
 }
 
 // ======================
-// 6. Client Pumps
+// 5. Client Pumps
 // ======================
 
+const maxMessageSize = 8 * 1024
+
+// reply queues a server → client control envelope.
+func (c *Client) reply(v interface{}) {
+	data, _ := json.Marshal(v)
+	select {
+	case c.Send <- data:
+	default:
+		log.Printf("Buffer full: dropping reply for %s", c.UserID)
+	}
+}
+
 func (c *Client) WritePump() {
+	c.lazy()
+	c.pumping.Store(true)
+	defer close(c.pumpDone)
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 	defer c.Conn.Close()
 
 	for {
 		select {
-		case msg, ok := <-c.Send:
+		case <-c.quit:
+			c.flush()
+			return
+		case msg := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, nil)
-				return
-			}
 			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				return
 			}
+			if c.spilling.Load() && len(c.Send) == 0 && c.hub != nil {
+				c.hub.drainSpill(c)
+			}
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -243,7 +483,7 @@ func (c *Client) WritePump() {
 }
 
 func (c *Client) ReadPump(h *Hub) {
-	c.Conn.SetReadLimit(512)
+	c.Conn.SetReadLimit(maxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -251,63 +491,71 @@ func (c *Client) ReadPump(h *Hub) {
 	})
 
 	for {
-		_, _, err := c.Conn.ReadMessage()
+		_, data, err := c.Conn.ReadMessage()
 		if err != nil {
 			break
 		}
-		// optional: process client → server messages
+		msg, err := h.HandleInbound(c, data)
+		if errors.Is(err, ErrRateLimited) {
+			continue
+		}
+		if err != nil {
+			c.reply(models.ErrorMessage{Type: "error", Message: err.Error()})
+			continue
+		}
+		if msg.Ephemeral() {
+			continue
+		}
+		c.reply(models.AckMessage{Type: "ack", ID: msg.ID, CreatedAt: msg.CreatedAt})
 	}
 
 	// Unregister **before** closing the connection
-	h.Unregister <- c
+	h.Unregister(c)
 	c.Conn.Close()
 }
 
 // ======================
-// 7. Public Broadcast
+// 6. Public Broadcast
 // ======================
 
 func Broadcast(msg *models.Message) {
-	Get().Broadcast <- msg
+	Get().Broadcast(msg)
 }
 
 // ======================
-// 8. Cleanup & Signals
+// 7. Cleanup
 // ======================
 
 func (h *Hub) cleanup() {
-	h.mu.Lock()
-	for _, list := range h.clients {
-		for _, c := range list {
-			close(c.Send)
-			c.Conn.Close()
+	for _, s := range h.shards {
+		s.mu.Lock()
+		for _, list := range s.clients {
+			for _, c := range list {
+				c.release(nil, time.Now().Add(unregisterDrain))
+				if c.Conn != nil {
+					c.Conn.Close()
+				}
+			}
 		}
+		s.clients = make(map[string][]*Client)
+		s.mu.Unlock()
 	}
-	h.clients = make(map[string][]*Client)
-	h.mu.Unlock()
 
-	if h.sub != nil {
-		_ = h.sub.Unsubscribe()
+	if h.cluster != nil {
+		h.cluster.stop()
 	}
-	if h.stompConn != nil {
-		_ = h.stompConn.Disconnect()
+	if h.broker != nil {
+		h.broker.stop()
 	}
 	log.Println("Hub cleaned")
 }
 
-func (h *Hub) handleSignals() {
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
-	log.Println("Shutting down...")
-	h.closeOnce.Do(func() { close(h.done) })
-}
-
 // GetClientsByUser returns a copy of the client slice for a user
 func (h *Hub) GetClientsByUser(userID string) []*Client {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	if clients, ok := h.clients[userID]; ok {
+	s := h.shardFor(userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if clients, ok := s.clients[userID]; ok {
 		out := make([]*Client, len(clients))
 		copy(out, clients)
 		return out
@@ -0,0 +1,554 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestClient(id, userID string) *Client {
+	return &Client{ID: id, UserID: userID, Send: make(chan []byte, 16)}
+}
+
+func TestStartAndStop(t *testing.T) {
+	h := New(Options{Shards: 4})
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Start(context.Background()); !errors.Is(err, ErrHubStarted) {
+		t.Fatalf("second Start err = %v, want ErrHubStarted", err)
+	}
+
+	alice := newTestClient("a1", "alice")
+	h.Register(alice)
+	waitFor(t, "registration", func() bool { return len(h.GetClientsByUser("alice")) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !alice.released() {
+		t.Fatal("socket left open after Stop")
+	}
+	if _, err := h.Deliver(&models.Message{RecipientID: "alice", Content: "hi"}); err == nil {
+		t.Fatal("stopped hub accepted a delivery")
+	}
+	if other := New(Options{}); len(other.GetClientsByUser("alice")) != 0 {
+		t.Fatal("hubs share state")
+	}
+}
+
+func TestStopFlushesAndSendsGoingAway(t *testing.T) {
+	h := New(Options{})
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := &Client{ID: "a1", UserID: "alice", Conn: conn, Send: make(chan []byte, 16)}
+		c.Send <- []byte(`"first"`)
+		c.Send <- []byte(`"second"`)
+		h.registerClient(c)
+		go c.WritePump()
+	}))
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	waitFor(t, "registration", func() bool { return len(h.GetClientsByUser("alice")) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := h.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			var ce *websocket.CloseError
+			if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway || !strings.Contains(ce.Text, "reconnect_after_ms=") {
+				t.Fatalf("close = %v, want going away with a reconnect hint", err)
+			}
+			break
+		}
+		got = append(got, string(data))
+	}
+	if strings.Join(got, ",") != `"first","second"` {
+		t.Fatalf("flushed %v", got)
+	}
+
+	if !h.Draining() {
+		t.Fatal("hub not draining after Stop")
+	}
+	late := newTestClient("a2", "alice")
+	h.registerClient(late)
+	if !late.released() || len(h.GetClientsByUser("alice")) != 0 {
+		t.Fatal("draining hub registered a socket")
+	}
+}
+
+func TestUnregisterFlushesBufferedFrames(t *testing.T) {
+	h := New(Options{})
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := &Client{ID: "a1", UserID: "alice", Conn: conn, Send: make(chan []byte, 16)}
+		h.registerClient(c)
+		go c.WritePump()
+		c.Send <- []byte(`"last"`)
+		h.unregisterClient(c)
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 20; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := ws.ReadMessage()
+		ws.Close()
+		if err != nil || string(data) != `"last"` {
+			t.Fatalf("attempt %d: got %q (%v), want the buffered frame", i, data, err)
+		}
+	}
+}
+
+func TestDeliverToConnectionTargetsOneDevice(t *testing.T) {
+	h := New(Options{})
+	phone := newTestClient("p1", "alice")
+	phone.Device = DeviceInfo{Platform: "ios", AppVersion: "2.1.0"}
+	laptop := newTestClient("l1", "alice")
+	h.registerClient(phone)
+	h.registerClient(laptop)
+
+	conns := h.Connections("alice")
+	if len(conns) != 2 || conns[0].ID != "p1" || conns[0].Device.Platform != "ios" || conns[0].Endpoint != EndpointWS {
+		t.Fatalf("connections %+v", conns)
+	}
+	before := h.ResumeCursor("alice").Seq
+	if _, err := h.DeliverToConnection("p1", &models.Message{RecipientID: "alice", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(phone.Send) != 1 || len(laptop.Send) != 0 {
+		t.Fatalf("phone got %d, laptop got %d", len(phone.Send), len(laptop.Send))
+	}
+	if h.ResumeCursor("alice").Seq != before {
+		t.Fatal("targeted message advanced the user's sequence")
+	}
+	if _, err := h.DeliverToConnection("nope", &models.Message{RecipientID: "alice"}); !errors.Is(err, ErrUnknownConnection) {
+		t.Fatalf("err = %v, want ErrUnknownConnection", err)
+	}
+
+	// Sockets are removed by identity, not by ID.
+	h.unregisterClient(&Client{ID: "p1", UserID: "alice"})
+	if len(h.GetClientsByUser("alice")) != 2 {
+		t.Fatal("unregistering a stranger with the same ID removed a socket")
+	}
+	// The close reason is cut to fit the frame without splitting a rune.
+	if err := h.Disconnect("l1", strings.Repeat("é", 100)); err != nil || !laptop.released() {
+		t.Fatalf("Disconnect: %v", err)
+	}
+	if reason := string(laptop.closeFrame[2:]); len(reason) > maxCloseReason || !utf8.ValidString(reason) {
+		t.Fatalf("close reason %q (%d bytes)", reason, len(reason))
+	}
+}
+
+func TestBroadcastGroupFanOut(t *testing.T) {
+	h := New(Options{})
+	alice := newTestClient("a1", "alice")
+	aliceTab := newTestClient("a2", "alice")
+	bob := newTestClient("b1", "bob")
+	carol := newTestClient("c1", "carol")
+	watcher := newTestClient("w1", "dave")
+	watcher.RoomID = "g1"
+	h.JoinGroup("g1", "alice", "bob")
+	for _, c := range []*Client{alice, aliceTab, bob, carol, watcher} {
+		h.registerClient(c)
+	}
+	if watcher.RoomID != "" {
+		t.Fatalf("non-member joined room %q", watcher.RoomID)
+	}
+
+	h.broadcastMessage(&models.Message{GroupID: "g1", Content: "hi"})
+
+	for _, c := range []*Client{alice, aliceTab, bob} {
+		if len(c.Send) != 1 {
+			t.Errorf("client %s got %d frames, want 1", c.ID, len(c.Send))
+		}
+	}
+	for _, c := range []*Client{carol, watcher} {
+		if len(c.Send) != 0 {
+			t.Errorf("non-member %s received %d frames", c.ID, len(c.Send))
+		}
+	}
+}
+
+func TestDeliverReportsDroppedSockets(t *testing.T) {
+	h := New(Options{})
+	bob := newTestClient("b1", "bob")
+	full := &Client{ID: "b2", UserID: "bob", Send: make(chan []byte)}
+	h.registerClient(bob)
+	h.registerClient(full)
+
+	report, err := h.Deliver(&models.Message{RecipientID: "bob", Content: "hi"})
+	if !errors.Is(err, ErrDeliveryIncomplete) {
+		t.Fatalf("err = %v, want ErrDeliveryIncomplete", err)
+	}
+	if report.Sockets != 1 || report.Dropped != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+}
+
+func TestOfflineMessagesFlushInOrderOnRegister(t *testing.T) {
+	h := New(Options{})
+	for _, content := range []string{"one", "two"} {
+		report, err := h.Deliver(&models.Message{RecipientID: "bob", Content: content, EventType: models.EventTypeSent})
+		if err != nil || report.Queued != 1 {
+			t.Fatalf("report = %+v, err = %v", report, err)
+		}
+	}
+	h.Deliver(&models.Message{SenderID: "alice", RecipientID: "bob", EventType: models.EventTypeTyping})
+
+	bob := newTestClient("b1", "bob")
+	h.registerClient(bob)
+	for _, want := range []string{"one", "two"} {
+		var msg models.Message
+		if err := json.Unmarshal(<-bob.Send, &msg); err != nil || msg.Content != want {
+			t.Fatalf("got %+v, want %q", msg, want)
+		}
+	}
+	if len(bob.Send) != 0 {
+		t.Fatalf("ephemeral event was stored: %d extra frames", len(bob.Send))
+	}
+}
+
+func TestRequeueKeepsUnacknowledgedMessagesForTheNextSocket(t *testing.T) {
+	h := New(Options{})
+	b1, b2 := newTestClient("b1", "bob"), newTestClient("b2", "bob")
+	h.registerClient(b1)
+	h.registerClient(b2)
+	unacked := []*models.Message{{ID: "m1", RecipientID: "bob", EventType: models.EventTypeSent, Seq: 1}}
+
+	if n := h.Requeue(b1, unacked); n != 0 {
+		t.Fatalf("requeued %d while another socket is live", n)
+	}
+	h.unregisterClient(b1)
+	if n := h.Requeue(b2, unacked); n != 1 {
+		t.Fatalf("requeued %d, want 1", n)
+	}
+	h.unregisterClient(b2)
+
+	b3 := newTestClient("b3", "bob")
+	h.registerClient(b3)
+	var msg models.Message
+	if err := json.Unmarshal(<-b3.Send, &msg); err != nil || msg.ID != "m1" {
+		t.Fatalf("got %+v, want the requeued message", msg)
+	}
+}
+
+func TestMemoryOfflineStoreBoundsAndExpires(t *testing.T) {
+	s := NewMemoryOfflineStore(2, time.Hour)
+	for _, id := range []string{"1", "2", "3"} {
+		s.Push("bob", &models.Message{ID: id})
+	}
+	got, _ := s.Pending("bob")
+	if len(got) != 2 || got[0].ID != "2" {
+		t.Fatalf("pending = %v, want the two newest", got)
+	}
+
+	s.queues["bob"][0].at = time.Now().Add(-2 * time.Hour)
+	got, _ = s.Pending("bob")
+	if len(got) != 1 || got[0].ID != "3" {
+		t.Fatalf("pending after expiry = %v", got)
+	}
+}
+
+func TestResumeReplaysGapOrAsksForResync(t *testing.T) {
+	h := New(Options{})
+	h.SetResumeWindow(2, time.Hour)
+	first := newTestClient("b1", "bob")
+	h.registerClient(first)
+	for _, content := range []string{"one", "two", "three"} {
+		h.Deliver(&models.Message{RecipientID: "bob", Content: content, EventType: models.EventTypeSent})
+	}
+	h.unregisterClient(first)
+	epoch := h.ResumeCursor("bob").Epoch
+
+	next := func(c *Client) models.Message {
+		t.Helper()
+		var msg models.Message
+		if err := json.Unmarshal(<-c.Send, &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	resumed := newTestClient("b2", "bob")
+	resumed.Resume = &Cursor{Epoch: epoch, Seq: 2}
+	h.registerClient(resumed)
+	if msg := next(resumed); msg.Seq != 3 || msg.Content != "three" {
+		t.Fatalf("replayed %+v, want seq 3", msg)
+	}
+	if len(resumed.Send) != 0 {
+		t.Fatalf("replayed %d extra frames", len(resumed.Send))
+	}
+
+	stale := newTestClient("b3", "bob")
+	stale.Resume = &Cursor{Epoch: epoch, Seq: 0} // seq 1 fell out of the ring
+	h.registerClient(stale)
+	if msg := next(stale); msg.EventType != models.EventTypeResync {
+		t.Fatalf("got %s, want %s", msg.EventType, models.EventTypeResync)
+	}
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	h := New(Options{})
+	content := func(data []byte) string {
+		var msg models.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg.Content
+	}
+	send := func(user string, contents ...string) {
+		for _, c := range contents {
+			if _, err := h.Deliver(&models.Message{RecipientID: user, Content: c, EventType: models.EventTypeSent}); err != nil {
+				t.Fatalf("deliver %q: %v", c, err)
+			}
+		}
+	}
+
+	oldest := &Client{ID: "a1", UserID: "alice", Send: make(chan []byte, 2), SlowPolicy: SlowDropOldest}
+	h.registerClient(oldest)
+	send("alice", "one", "two", "three")
+	if got := content(<-oldest.Send) + content(<-oldest.Send); got != "twothree" {
+		t.Fatalf("drop-oldest kept %q", got)
+	}
+
+	spill := &Client{ID: "b1", UserID: "bob", Send: make(chan []byte, 2), SlowPolicy: SlowSpill}
+	h.registerClient(spill)
+	send("bob", "one", "two", "three", "four")
+	var got string
+	for len(spill.Send) > 0 {
+		got += content(<-spill.Send)
+		if len(spill.Send) == 0 && spill.spilling.Load() {
+			h.drainSpill(spill)
+		}
+	}
+	if got != "onetwothreefour" || spill.spilling.Load() {
+		t.Fatalf("spill delivered %q, spilling=%v", got, spill.spilling.Load())
+	}
+
+	gone := &Client{ID: "c1", UserID: "carol", Send: make(chan []byte, 1), SlowPolicy: SlowDisconnect}
+	h.registerClient(gone)
+	send("carol", "one", "two", "three")
+
+	stats := h.SlowConsumerStats()
+	if stats["alice"].DroppedOldest != 1 || stats["bob"].Spilled != 2 || stats["carol"].Disconnects != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if slowest := h.SlowestUsers(1); len(slowest) != 1 || slowest[0] != "bob" {
+		t.Fatalf("slowest = %v", slowest)
+	}
+	// The counted users are bounded.
+	for i := 0; i < slowStatsMax; i++ {
+		h.slow.record(fmt.Sprint("u", i), func(st *SlowConsumerStats) { st.Dropped++ })
+	}
+	if n := len(h.SlowConsumerStats()); n > slowStatsMax {
+		t.Fatalf("tracking %d users, want at most %d", n, slowStatsMax)
+	}
+}
+
+func TestEncodingSplicesPerUserSeq(t *testing.T) {
+	h := New(Options{})
+	e := encode(&models.Message{ID: "m1", RecipientID: "bob", Content: "hi", EventType: models.EventTypeSent, Seq: 99})
+	for want := uint64(1); want <= 2; want++ {
+		m, data := e.forUser(h.seqs, "bob")
+		var got models.Message
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if got.Seq != want || m.Seq != want || got.Content != "hi" {
+			t.Fatalf("got %+v from %s, want seq %d", got, data, want)
+		}
+	}
+}
+
+func TestLeaveGroupAndRoom(t *testing.T) {
+	h := New(Options{})
+	bob := newTestClient("b1", "bob")
+	bob.RoomID = "g1"
+	h.JoinGroup("g1", "bob")
+	h.registerClient(bob)
+	if bob.RoomID != "g1" {
+		t.Fatal("member did not join the room")
+	}
+
+	// Leaving the group alone stops room traffic too.
+	h.LeaveGroup("g1", "bob")
+	h.broadcastMessage(&models.Message{GroupID: "g1", Content: "hi"})
+	if len(bob.Send) != 0 {
+		t.Fatalf("former member received %d frames", len(bob.Send))
+	}
+	h.LeaveRoom(bob)
+	if got := h.GroupMembers("g1"); len(got) != 0 {
+		t.Fatalf("GroupMembers = %v, want empty", got)
+	}
+}
+
+func TestHandleInboundStampsAndValidates(t *testing.T) {
+	h := New(Options{})
+	alice := newTestClient("a1", "alice")
+
+	msg, err := h.HandleInbound(alice, []byte(`{"id":"forged","sender_id":"mallory","recipient_id":"bob","content":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.SenderID != "alice" || msg.ID == "forged" || msg.CreatedAt == "" || msg.EventType != models.EventTypeSent {
+		t.Fatalf("message not stamped: %+v", msg)
+	}
+	if got := nextBroadcast(t, h); got != msg {
+		t.Fatal("accepted message was not routed through the hub")
+	}
+
+	edit, err := h.HandleInbound(alice, []byte(`{"id":"m1","recipient_id":"bob","content":"hi!","event_type":"message.edited","seq":9}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit.TargetID != "m1" || edit.ID == "m1" || edit.Seq != 0 {
+		t.Fatalf("edit not stamped: %+v", edit)
+	}
+	nextBroadcast(t, h)
+
+	cases := map[string]string{
+		"no recipient":   `{"content":"hi"}`,
+		"empty content":  `{"recipient_id":"bob"}`,
+		"no target":      `{"recipient_id":"bob","event_type":"message.deleted"}`,
+		"not a member":   `{"group_id":"g1","content":"hi"}`,
+		"unknown event":  `{"recipient_id":"bob","content":"hi","event_type":"presence.online"}`,
+		"malformed json": `{"recipient_id":`,
+	}
+	for name, raw := range cases {
+		if _, err := h.HandleInbound(alice, []byte(raw)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTypingSkipsSenderAndIsThrottled(t *testing.T) {
+	h := New(Options{})
+	alice := newTestClient("a1", "alice")
+	aliceTab := newTestClient("a2", "alice")
+	bob := newTestClient("b1", "bob")
+	for _, c := range []*Client{alice, aliceTab, bob} {
+		h.registerClient(c)
+	}
+	h.JoinGroup("g1", "alice", "bob")
+
+	typing := []byte(`{"group_id":"g1","event_type":"typing.start"}`)
+	if _, err := h.HandleInbound(alice, typing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.HandleInbound(alice, typing); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("second typing.start err = %v, want ErrRateLimited", err)
+	}
+	h.broadcastMessage(nextBroadcast(t, h))
+
+	if len(bob.Send) != 1 {
+		t.Errorf("bob got %d frames, want 1", len(bob.Send))
+	}
+	if len(alice.Send)+len(aliceTab.Send) != 0 {
+		t.Error("typing indicator echoed to the sender's own sockets")
+	}
+
+	if _, err := h.HandleInbound(alice, []byte(`{"group_id":"g1","event_type":"typing.stop"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.ephemeral.typing); n != 0 {
+		t.Fatalf("%d typing timers still armed after typing.stop", n)
+	}
+}
+
+func TestPresenceGraceAbsorbsReconnect(t *testing.T) {
+	h := New(Options{})
+	h.presence.grace = 20 * time.Millisecond
+	watcher := newTestClient("w1", "carol")
+	h.registerClient(watcher)
+	h.WatchPresence(watcher, []string{"bob"})
+	expectEvent(t, watcher, models.EventTypePresenceOffline) // snapshot
+
+	bob := newTestClient("b1", "bob")
+	h.registerClient(bob)
+	expectEvent(t, watcher, models.EventTypePresenceOnline)
+
+	h.unregisterClient(bob)
+	h.registerClient(newTestClient("b2", "bob"))
+	time.Sleep(50 * time.Millisecond)
+	if len(watcher.Send) != 0 {
+		t.Fatalf("reconnect inside grace emitted %d events", len(watcher.Send))
+	}
+
+	h.unregisterClient(h.GetClientsByUser("bob")[0])
+	if got := h.Presence("bob")[0]; !got.Online {
+		t.Fatal("user reported offline during grace period")
+	}
+	time.Sleep(50 * time.Millisecond)
+	expectEvent(t, watcher, models.EventTypePresenceOffline)
+	if got := h.Presence("bob")[0]; got.Online || got.LastSeen == "" {
+		t.Fatalf("Presence = %+v, want offline with last_seen", got)
+	}
+}
+
+// nextBroadcast takes the message Broadcast queued on whichever shard.
+func nextBroadcast(t *testing.T, h *Hub) *models.Message {
+	t.Helper()
+	for _, s := range h.shards {
+		select {
+		case msg := <-s.broadcast:
+			return msg
+		default:
+		}
+	}
+	t.Fatal("nothing was broadcast")
+	return nil
+}
+
+func expectEvent(t *testing.T, c *Client, eventType string) {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		var msg models.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.EventType != eventType {
+			t.Fatalf("got %s, want %s", msg.EventType, eventType)
+		}
+	default:
+		t.Fatalf("no %s event delivered", eventType)
+	}
+}
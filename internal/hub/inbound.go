@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gofrs/uuid"
+)
+
+// ======================
+// Inbound Messages
+// ======================
+
+var (
+	ErrNoRecipient      = errors.New("message needs a recipient_id or group_id")
+	ErrEmptyContent     = errors.New("message content is empty")
+	ErrNoTarget         = errors.New("event needs the target_id of the message it changes")
+	ErrNotGroupMember   = errors.New("sender is not a member of the group")
+	ErrUnknownEventType = errors.New("unknown event_type")
+)
+
+// inboundEventTypes are the events clients may originate.
+var inboundEventTypes = map[string]bool{
+	models.EventTypeSent:       true,
+	models.EventTypeEdited:     true,
+	models.EventTypeDeleted:    true,
+	models.EventTypeRead:       true,
+	models.EventTypeTyping:     true,
+	models.EventTypeStopTyping: true,
+}
+
+// HandleInbound decodes a client → server envelope, stamps the server-owned
+// fields, validates it and routes it through the hub, or through the broker
+// when it is published to a chat destination the hub consumes. The
+// accepted message is returned so the caller can acknowledge it.
+func (h *Hub) HandleInbound(c *Client, raw []byte) (*models.Message, error) {
+	var msg models.Message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	Stamp(c.UserID, &msg)
+
+	switch msg.EventType {
+	case models.EventTypePresenceSubscribe:
+		h.WatchPresence(c, presenceUserIDs(&msg))
+		return &msg, nil
+	case models.EventTypePresenceUnsubscribe:
+		h.UnwatchPresence(c, presenceUserIDs(&msg))
+		return &msg, nil
+	}
+
+	if err := h.Validate(&msg); err != nil {
+		return nil, err
+	}
+
+	if msg.Ephemeral() {
+		if err := h.routeEphemeral(&msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	}
+	if !h.publishInbound(&msg) {
+		h.Broadcast(&msg)
+	}
+	return &msg, nil
+}
+
+// targetEvents refer to an earlier message. Clients name it in target_id,
+// or in id for compatibility.
+var targetEvents = map[string]bool{
+	models.EventTypeEdited:  true,
+	models.EventTypeDeleted: true,
+	models.EventTypeRead:    true,
+}
+
+// Stamp overwrites the fields a client must not choose: the sender is the
+// authenticated user, ID/CreatedAt are assigned by the server and Seq is
+// set on delivery. The ID a client gave an edit, delete or read becomes
+// its TargetID.
+func Stamp(senderID string, msg *models.Message) {
+	if targetEvents[msg.EventType] && msg.TargetID == "" {
+		msg.TargetID = msg.ID
+	}
+	id, _ := uuid.NewV4()
+	msg.ID = id.String()
+	msg.SenderID = senderID
+	msg.Seq = 0
+	msg.Redeliveries = 0
+	msg.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	if msg.EventType == "" {
+		msg.EventType = models.EventTypeSent
+	}
+	if msg.MessageType == "" {
+		msg.MessageType = "text"
+	}
+}
+
+// Validate checks that a stamped message can be routed.
+func (h *Hub) Validate(msg *models.Message) error {
+	if !inboundEventTypes[msg.EventType] {
+		return fmt.Errorf("%w: %q", ErrUnknownEventType, msg.EventType)
+	}
+	if msg.RecipientID == "" && msg.GroupID == "" {
+		return ErrNoRecipient
+	}
+	if msg.Content == "" && (msg.EventType == models.EventTypeSent || msg.EventType == models.EventTypeEdited) {
+		return ErrEmptyContent
+	}
+	if msg.TargetID == "" && (msg.EventType == models.EventTypeEdited || msg.EventType == models.EventTypeDeleted) {
+		return ErrNoTarget
+	}
+	if msg.GroupID != "" && !h.IsGroupMember(msg.GroupID, msg.SenderID) {
+		return ErrNotGroupMember
+	}
+	return nil
+}
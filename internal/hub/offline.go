@@ -0,0 +1,254 @@
+package hub
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/models"
+)
+
+// ======================
+// Offline Queue
+// ======================
+
+// Messages for users without a live socket anywhere in the cluster are kept
+// in an OfflineStore and flushed, oldest first, when the user's next socket
+// is ready, unless the socket resumes from a cursor (see resume.go).
+// Ephemeral events are never stored. The store is per node unless the
+// implementation is shared, so a user reconnecting to another instance only
+// gets what that instance stored.
+
+const (
+	DefaultOfflineMaxPerUser = 100
+	DefaultOfflineTTL        = 24 * time.Hour
+)
+
+// OfflineStore holds pending messages per user. Pending returns a user's
+// unexpired messages oldest first; Remove drops the first n of them once
+// they were delivered.
+type OfflineStore interface {
+	Push(userID string, msg *models.Message) error
+	Pending(userID string) ([]*models.Message, error)
+	Remove(userID string, n int) error
+}
+
+// SetOfflineStore replaces the offline store; nil disables queueing.
+func (h *Hub) SetOfflineStore(s OfflineStore) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.offline = s
+}
+
+// queueOfflineLocked stores msg for a recipient without a local socket
+// unless another node holds one. Callers must hold h.mu and the user's
+// shard lock, so the user cannot register in between.
+func (h *Hub) queueOfflineLocked(userID string, msg *models.Message) (queued, ok bool) {
+	if h.offline == nil || msg.Ephemeral() || len(h.cluster.remoteNodes(userID)) > 0 {
+		return false, true
+	}
+	if err := h.offline.Push(userID, h.seqs.stamp(userID, msg)); err != nil {
+		log.Printf("Offline queue for %s failed: %v", userID, err)
+		return false, false
+	}
+	return true, true
+}
+
+// Requeue stores messages c received but never acknowledged so the user's
+// next socket gets them again, and returns how many it stored. It stores
+// nothing while the user has another live socket here or elsewhere in the
+// cluster, since that socket got the messages too. Call it before
+// Unregister so messages queued after the socket is gone stay behind them.
+func (h *Hub) Requeue(c *Client, msgs []*models.Message) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.offline == nil || len(msgs) == 0 {
+		return 0
+	}
+	s := h.shardFor(c.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.clients[c.UserID] {
+		if other != c && !other.released() {
+			return 0
+		}
+	}
+	if len(h.cluster.remoteNodes(c.UserID)) > 0 {
+		return 0
+	}
+
+	n := 0
+	for _, m := range msgs {
+		if m.Ephemeral() {
+			continue
+		}
+		if err := h.offline.Push(c.UserID, m); err != nil {
+			log.Printf("Offline queue for %s failed: %v", c.UserID, err)
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		log.Printf("Requeued %d unacknowledged messages of %s", n, c.ID)
+	}
+	return n
+}
+
+// CatchUp brings c up to date once it can take frames: it replays from
+// c.Resume when set, otherwise flushes the offline queue. Registration
+// calls it; codec clients call it again once subscribed.
+func (h *Hub) CatchUp(c *Client) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := h.shardFor(c.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h.catchUpLocked(c)
+}
+
+// flushOfflineLocked queues c's stored messages on it, oldest first, and
+// returns how many. It stops at the first message the socket refuses (a
+// full buffer, or a codec with no matching subscription yet) and keeps the
+// rest. Callers must hold h.mu and c's shard lock for writing so no live
+// message overtakes them.
+func (h *Hub) flushOfflineLocked(c *Client) int {
+	if h.offline == nil {
+		return 0
+	}
+	msgs, err := h.offline.Pending(c.UserID)
+	if err != nil {
+		log.Printf("Offline queue for %s unreadable: %v", c.UserID, err)
+		return 0
+	}
+
+	n := 0
+	for _, m := range msgs {
+		if !h.queueOn(c, m) {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	if err := h.offline.Remove(c.UserID, n); err != nil {
+		log.Printf("Offline queue for %s: remove failed: %v", c.UserID, err)
+	}
+	log.Printf("Flushed %d offline messages to %s", n, c.ID)
+	return n
+}
+
+// trimOfflineLocked drops stored messages a replay up to seq covered.
+func (h *Hub) trimOfflineLocked(userID string, seq uint64) {
+	if h.offline == nil {
+		return
+	}
+	msgs, err := h.offline.Pending(userID)
+	if err != nil {
+		return
+	}
+	n := 0
+	for n < len(msgs) && msgs[n].Seq != 0 && msgs[n].Seq <= seq {
+		n++
+	}
+	if n > 0 {
+		_ = h.offline.Remove(userID, n)
+	}
+}
+
+// MemoryOfflineStore keeps at most maxPerUser messages per user, dropping
+// the oldest when full, and forgets messages older than ttl.
+type MemoryOfflineStore struct {
+	maxPerUser int
+	ttl        time.Duration
+
+	mu        sync.Mutex
+	queues    map[string][]offlineEntry
+	lastSweep time.Time
+}
+
+type offlineEntry struct {
+	msg *models.Message
+	at  time.Time
+}
+
+func NewMemoryOfflineStore(maxPerUser int, ttl time.Duration) *MemoryOfflineStore {
+	if maxPerUser <= 0 {
+		maxPerUser = DefaultOfflineMaxPerUser
+	}
+	if ttl <= 0 {
+		ttl = DefaultOfflineTTL
+	}
+	return &MemoryOfflineStore{
+		maxPerUser: maxPerUser,
+		ttl:        ttl,
+		queues:     make(map[string][]offlineEntry),
+		lastSweep:  time.Now(),
+	}
+}
+
+func (s *MemoryOfflineStore) Push(userID string, msg *models.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > s.ttl/10 {
+		// Users who never come back would otherwise keep their queue.
+		for uid := range s.queues {
+			s.expireLocked(uid, now)
+		}
+		s.lastSweep = now
+	}
+
+	q := append(s.expireLocked(userID, now), offlineEntry{msg: msg, at: now})
+	if drop := len(q) - s.maxPerUser; drop > 0 {
+		log.Printf("Offline queue for %s full: dropping %d oldest", userID, drop)
+		q = append([]offlineEntry(nil), q[drop:]...)
+	}
+	s.queues[userID] = q
+	return nil
+}
+
+func (s *MemoryOfflineStore) Pending(userID string) ([]*models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := s.expireLocked(userID, time.Now())
+	out := make([]*models.Message, len(q))
+	for i, e := range q {
+		out[i] = e.msg
+	}
+	return out, nil
+}
+
+func (s *MemoryOfflineStore) Remove(userID string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := s.queues[userID]
+	if n >= len(q) {
+		delete(s.queues, userID)
+		return nil
+	}
+	s.queues[userID] = append([]offlineEntry(nil), q[n:]...)
+	return nil
+}
+
+// expireLocked drops a user's expired messages and returns the rest.
+// Callers must hold s.mu.
+func (s *MemoryOfflineStore) expireLocked(userID string, now time.Time) []offlineEntry {
+	q := s.queues[userID]
+	i := 0
+	for i < len(q) && now.Sub(q[i].at) > s.ttl {
+		i++
+	}
+	if i == len(q) {
+		delete(s.queues, userID)
+		return nil
+	}
+	if i > 0 {
+		q = append([]offlineEntry(nil), q[i:]...)
+		s.queues[userID] = q
+	}
+	return q
+}
@@ -0,0 +1,289 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/models"
+)
+
+// ======================
+// Presence
+// ======================
+
+// defaultPresenceGrace absorbs quick reconnects: a user whose last socket
+// closes stays online this long before presence.offline is emitted.
+const defaultPresenceGrace = 5 * time.Second
+
+const maxPresenceWatch = 500 // contacts per socket
+
+type presenceTracker struct {
+	mu       sync.Mutex
+	grace    time.Duration
+	lastSeen map[string]time.Time            // userID → last socket closed
+	offline  map[string]*time.Timer          // userID → pending offline
+	watchers map[string]map[*Client]struct{} // watched userID → sockets
+	watching map[*Client]map[string]struct{} // socket → watched userIDs
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		grace:    defaultPresenceGrace,
+		lastSeen: make(map[string]time.Time),
+		offline:  make(map[string]*time.Timer),
+		watchers: make(map[string]map[*Client]struct{}),
+		watching: make(map[*Client]map[string]struct{}),
+	}
+}
+
+// Presence reports the online state of each user. Users inside the
+// reconnect grace period are still reported online.
+func (h *Hub) Presence(userIDs ...string) []models.PresenceStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	out := make([]models.PresenceStatus, 0, len(userIDs))
+	for _, uid := range userIDs {
+		h.withPresence(uid, func() {
+			out = append(out, h.presenceStatusLocked(uid))
+		})
+	}
+	return out
+}
+
+// withPresence runs fn holding the user's shard lock and h.presence.mu,
+// in that order.
+func (h *Hub) withPresence(userID string, fn func()) {
+	s := h.shardFor(userID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h.presence.mu.Lock()
+	defer h.presence.mu.Unlock()
+	fn()
+}
+
+// localSockets counts a user's sockets on this node. Callers must hold the
+// user's shard lock.
+func (h *Hub) localSockets(userID string) int {
+	return len(h.shardFor(userID).clients[userID])
+}
+
+// presenceStatusLocked needs h.mu, the user's shard lock and
+// h.presence.mu held.
+func (h *Hub) presenceStatusLocked(userID string) models.PresenceStatus {
+	p := h.presence
+	st := models.PresenceStatus{UserID: userID}
+	_, inGrace := p.offline[userID]
+	st.Online = h.localSockets(userID) > 0 || inGrace || len(h.cluster.remoteNodes(userID)) > 0
+	if at, ok := p.lastSeen[userID]; ok && !st.Online {
+		st.LastSeen = at.UTC().Format(time.RFC3339)
+	}
+	return st
+}
+
+// presenceConnected runs after a socket registers. Callers must hold h.mu
+// and c's shard lock.
+func (h *Hub) presenceConnected(c *Client) {
+	if h.localSockets(c.UserID) != 1 {
+		return
+	}
+	p := h.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if timer, ok := p.offline[c.UserID]; ok {
+		timer.Stop()
+		delete(p.offline, c.UserID)
+		return // reconnect inside the grace period: nobody saw us leave
+	}
+	h.cluster.announce(c.UserID, true)
+	if len(h.cluster.remoteNodes(c.UserID)) == 0 {
+		h.notifyPresenceLocked(c.UserID, models.EventTypePresenceOnline)
+	}
+}
+
+// presenceDisconnected runs after a socket unregisters. Callers must hold
+// h.mu and c's shard lock.
+func (h *Hub) presenceDisconnected(c *Client) {
+	p := h.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for uid := range p.watching[c] {
+		delete(p.watchers[uid], c)
+		if len(p.watchers[uid]) == 0 {
+			delete(p.watchers, uid)
+		}
+	}
+	delete(p.watching, c)
+
+	if h.localSockets(c.UserID) > 0 {
+		return
+	}
+	if _, ok := p.offline[c.UserID]; ok {
+		return
+	}
+	userID := c.UserID
+	p.offline[userID] = time.AfterFunc(p.grace, func() { h.expirePresence(userID) })
+}
+
+func (h *Hub) expirePresence(userID string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.withPresence(userID, func() {
+		p := h.presence
+		if _, ok := p.offline[userID]; !ok {
+			return // cancelled by a reconnect
+		}
+		delete(p.offline, userID)
+		if h.localSockets(userID) > 0 {
+			return
+		}
+		h.cluster.announce(userID, false)
+		if len(h.cluster.remoteNodes(userID)) == 0 {
+			p.lastSeen[userID] = time.Now()
+			h.notifyPresenceLocked(userID, models.EventTypePresenceOffline)
+		}
+	})
+}
+
+// remotePresenceChanged notifies local watchers of users that appeared on,
+// or vanished from, every other node while having no local socket.
+func (h *Hub) remotePresenceChanged(userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	p := h.presence
+
+	for _, uid := range userIDs {
+		h.withPresence(uid, func() {
+			if _, inGrace := p.offline[uid]; inGrace || h.localSockets(uid) > 0 {
+				return
+			}
+			if len(h.cluster.remoteNodes(uid)) > 0 {
+				h.notifyPresenceLocked(uid, models.EventTypePresenceOnline)
+			} else {
+				p.lastSeen[uid] = time.Now()
+				h.notifyPresenceLocked(uid, models.EventTypePresenceOffline)
+			}
+		})
+	}
+}
+
+// WatchPresence subscribes a socket to presence changes of its contacts and
+// immediately sends their current state.
+func (h *Hub) WatchPresence(c *Client, userIDs []string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	p := h.presence
+
+	var added []string
+	h.withPresence(c.UserID, func() {
+		if !h.shardFor(c.UserID).registeredLocked(c) {
+			return // unregistered sockets must not become watchers
+		}
+		watched, ok := p.watching[c]
+		if !ok {
+			watched = make(map[string]struct{})
+			p.watching[c] = watched
+		}
+		for _, uid := range userIDs {
+			if uid == "" || len(watched) >= maxPresenceWatch {
+				continue
+			}
+			watched[uid] = struct{}{}
+			if p.watchers[uid] == nil {
+				p.watchers[uid] = make(map[*Client]struct{})
+			}
+			p.watchers[uid][c] = struct{}{}
+			added = append(added, uid)
+		}
+	})
+
+	events := make([]string, len(added))
+	for i, uid := range added {
+		h.withPresence(uid, func() {
+			events[i] = models.EventTypePresenceOffline
+			if h.presenceStatusLocked(uid).Online {
+				events[i] = models.EventTypePresenceOnline
+			}
+		})
+	}
+
+	s := h.shardFor(c.UserID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.registeredLocked(c) {
+		return
+	}
+	for i, uid := range added {
+		deliverPresence(c, uid, events[i])
+	}
+}
+
+// UnwatchPresence stops presence notifications for the given contacts.
+func (h *Hub) UnwatchPresence(c *Client, userIDs []string) {
+	p := h.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, uid := range userIDs {
+		delete(p.watching[c], uid)
+		delete(p.watchers[uid], c)
+		if len(p.watchers[uid]) == 0 {
+			delete(p.watchers, uid)
+		}
+	}
+}
+
+// notifyWatchers pushes a presence event that originated outside the hub
+// (e.g. the presence service) to local watchers.
+func (h *Hub) notifyWatchers(userID, eventType string) {
+	p := h.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h.notifyPresenceLocked(userID, eventType)
+}
+
+// notifyPresenceLocked needs h.presence.mu held.
+func (h *Hub) notifyPresenceLocked(userID, eventType string) {
+	log.Printf("Presence: %s %s", userID, eventType)
+	for c := range h.presence.watchers[userID] {
+		deliverPresence(c, userID, eventType)
+	}
+}
+
+func deliverPresence(c *Client, userID, eventType string) {
+	msg := &models.Message{
+		SenderID:    userID,
+		RecipientID: c.UserID,
+		EventType:   eventType,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	data, _ := json.Marshal(msg)
+	payload, ok := c.frame(msg, data)
+	if !ok {
+		return
+	}
+	select {
+	case c.Send <- payload:
+	default:
+		log.Printf("Buffer full: dropping presence for %s", c.UserID)
+	}
+}
+
+// presenceUserIDs reads metadata.user_ids from a presence control event.
+func presenceUserIDs(msg *models.Message) []string {
+	raw, _ := msg.Metadata["user_ids"].([]interface{})
+	out := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
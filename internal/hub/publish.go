@@ -0,0 +1,224 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"go-gin-example/internal/models"
+
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// ======================
+// Broker Publishing
+// ======================
+
+// Events leave the gateway on the broker so persistence and notification
+// services see them. The destination is picked per event type, falling back
+// to a default; every frame carries content-type, correlation-id and
+// event-type headers. With receipts on, Publish returns only once the broker
+// confirmed the frame. A caller naming its own destination may only pick one
+// of the configured ones, so the publish API cannot reach other queues or
+// the cluster bus topics on the same broker.
+//
+// Client messages are published from a bounded queue so a slow broker, or
+// one confirming every frame with a RECEIPT, does not stall the read pumps
+// that accepted them. When they are published to a destination the hub
+// consumes as chat, they reach their recipients through the broker and are
+// not delivered locally as well.
+
+const publishQueueSize = 1024
+
+var (
+	ErrNoPublishDestination = errors.New("no publish destination configured")
+	ErrReservedHeader       = errors.New("header is set by the gateway")
+	ErrDestinationForbidden = errors.New("destination is not a publish destination")
+)
+
+// reservedHeaders are managed by Publish or the STOMP client and cannot be
+// passed in PublishOptions.Headers.
+var reservedHeaders = map[string]bool{
+	frame.Destination:   true,
+	frame.ContentType:   true,
+	frame.ContentLength: true,
+	frame.Receipt:       true,
+	frame.Transaction:   true,
+	"correlation-id":    true,
+	"event-type":        true,
+}
+
+// PublishConfig routes published events to broker destinations.
+type PublishConfig struct {
+	Destination string            // default destination; empty disables publishing
+	Routes      map[string]string // event type → destination
+	Receipts    bool              // wait for a broker RECEIPT on every publish
+	Allowed     []string          // further destinations PublishOptions may name
+}
+
+// allows reports whether dest may be named in PublishOptions: the default,
+// a routed destination or an allowed one.
+func (c PublishConfig) allows(dest string) bool {
+	if dest == c.Destination || slices.Contains(c.Allowed, dest) {
+		return true
+	}
+	for _, d := range c.Routes {
+		if d == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the destination msg is published to by default.
+func (c PublishConfig) route(msg *models.Message) string {
+	if dest := c.Routes[msg.EventType]; dest != "" {
+		return dest
+	}
+	return c.Destination
+}
+
+// PublishOptions tunes a single Publish call.
+type PublishOptions struct {
+	Destination   string            // overrides the routed destination; must be configured
+	CorrelationID string            // defaults to the message ID
+	Receipt       bool              // wait for a RECEIPT even if the hub default is off
+	Headers       map[string]string // extra frame headers
+}
+
+// PublishResult describes a frame handed to the broker.
+type PublishResult struct {
+	Destination   string `json:"destination"`
+	CorrelationID string `json:"correlation_id"`
+	Receipt       bool   `json:"receipt"` // the broker confirmed the frame
+}
+
+// SetPublishing replaces the publish routing.
+func (h *Hub) SetPublishing(cfg PublishConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publishing = cfg
+}
+
+// SetPublishDestination makes accepted client messages also go to the STOMP
+// broker so downstream services (persistence, notifications) see them.
+func (h *Hub) SetPublishDestination(dest string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publishing.Destination = dest
+}
+
+// Publish sends msg to the broker as JSON.
+func (h *Hub) Publish(msg *models.Message, opts PublishOptions) (PublishResult, error) {
+	h.mu.RLock()
+	b, cfg := h.broker, h.publishing
+	h.mu.RUnlock()
+
+	dest := opts.Destination
+	if dest != "" && !cfg.allows(dest) {
+		return PublishResult{}, fmt.Errorf("%w: %s", ErrDestinationForbidden, dest)
+	}
+	if dest == "" {
+		dest = cfg.route(msg)
+	}
+	if dest == "" {
+		return PublishResult{}, ErrNoPublishDestination
+	}
+
+	keys := make([]string, 0, len(opts.Headers))
+	for k := range opts.Headers {
+		if reservedHeaders[strings.ToLower(k)] {
+			return PublishResult{}, fmt.Errorf("%w: %s", ErrReservedHeader, k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	res := PublishResult{Destination: dest, CorrelationID: opts.CorrelationID}
+	if res.CorrelationID == "" {
+		res.CorrelationID = msg.ID
+	}
+	if b == nil {
+		return res, ErrBrokerUnavailable
+	}
+
+	sendOpts := []func(*frame.Frame) error{
+		stomp.SendOpt.Header("correlation-id", res.CorrelationID),
+		stomp.SendOpt.Header("event-type", msg.EventType),
+	}
+	for _, k := range keys {
+		sendOpts = append(sendOpts, stomp.SendOpt.Header(k, opts.Headers[k]))
+	}
+	receipt := opts.Receipt || cfg.Receipts
+	if receipt {
+		sendOpts = append(sendOpts, stomp.SendOpt.Receipt)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return res, err
+	}
+	if err := b.send(dest, "application/json", data, sendOpts...); err != nil {
+		return res, fmt.Errorf("publish to %s: %w", dest, err)
+	}
+	res.Receipt = receipt
+	return res, nil
+}
+
+type inboundPublish struct {
+	msg      *models.Message
+	loopback bool // the broker brings it back to the hub
+}
+
+// publishInbound queues an accepted client message for the broker and
+// reports whether it comes back through a chat destination, in which case
+// the caller must not deliver it too. A full queue drops the publish and
+// leaves delivery to the caller.
+func (h *Hub) publishInbound(msg *models.Message) bool {
+	h.mu.RLock()
+	dest := h.publishing.route(msg)
+	loopback := h.loopback[dest]
+	h.mu.RUnlock()
+	if dest == "" {
+		return false
+	}
+	select {
+	case h.outbound <- inboundPublish{msg: msg, loopback: loopback}:
+		return loopback
+	default:
+		log.Printf("STOMP publish queue full: dropping %s", msg.ID)
+		return false
+	}
+}
+
+// runPublisher publishes queued client messages in order. Failures are
+// logged, as the broker may be down under the degrade startup policy; a
+// message that was to come back through the broker is delivered locally
+// instead.
+func (h *Hub) runPublisher(ctx context.Context) {
+	for {
+		select {
+		case p := <-h.outbound:
+			_, err := h.Publish(p.msg, PublishOptions{})
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, ErrNoPublishDestination) {
+				log.Printf("STOMP publish of %s failed: %v", p.msg.ID, err)
+			}
+			if p.loopback {
+				h.Broadcast(p.msg)
+			}
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			return
+		}
+	}
+}
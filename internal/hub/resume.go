@@ -0,0 +1,232 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gofrs/uuid"
+)
+
+// ======================
+// Resumable Sessions
+// ======================
+
+// Every non-ephemeral event delivered to a user gets the next number of
+// that user's sequence, and the most recent ones are kept in a ring. A
+// reconnecting client passes the last sequence it saw (its Cursor) and the
+// gap is replayed before live traffic; when the ring no longer covers the
+// gap the client is sent a session.resync event and must reload.
+//
+// Sequences are per instance. The epoch identifies one sequence: it changes
+// when an idle user's log is dropped or the process restarts, and a cursor
+// from another epoch always needs a resync.
+
+const (
+	DefaultResumeBuffer = 256
+	DefaultResumeTTL    = 24 * time.Hour
+)
+
+// Cursor is a position in a user's event sequence.
+type Cursor struct {
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+type userLog struct {
+	epoch   string
+	last    uint64
+	ring    []*models.Message // circular, holds the last len(ring) events
+	n       int               // events in ring
+	touched time.Time
+}
+
+type sequencer struct {
+	size int
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	logs      map[string]*userLog
+	lastSweep time.Time
+}
+
+func newSequencer(size int, ttl time.Duration) *sequencer {
+	if size <= 0 {
+		size = DefaultResumeBuffer
+	}
+	if ttl <= 0 {
+		ttl = DefaultResumeTTL
+	}
+	return &sequencer{size: size, ttl: ttl, logs: make(map[string]*userLog), lastSweep: time.Now()}
+}
+
+// ParseCursor reads a client-supplied position; an empty seq means the
+// client is not resuming.
+func ParseCursor(epoch, seq string) (*Cursor, error) {
+	if seq == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid last_seq %q", seq)
+	}
+	return &Cursor{Epoch: epoch, Seq: n}, nil
+}
+
+// ResumeFrom makes c replay from cur when it catches up. It must be called
+// before c can take frames, i.e. before registering or subscribing.
+func (h *Hub) ResumeFrom(c *Client, cur Cursor) {
+	s := h.shardFor(c.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.Resume = &cur
+}
+
+// SetResumeWindow sets how many events per user can be replayed and how
+// long an idle user's sequence is kept. Call it before serving traffic.
+func (h *Hub) SetResumeWindow(size int, ttl time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seqs = newSequencer(size, ttl)
+}
+
+// ResumeCursor returns the user's current position, starting a sequence
+// if there is none.
+func (h *Hub) ResumeCursor(userID string) Cursor {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := h.seqs
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.logLocked(userID, time.Now())
+	return Cursor{Epoch: l.epoch, Seq: l.last}
+}
+
+// logLocked returns the user's log, creating it. Callers must hold s.mu.
+func (s *sequencer) logLocked(userID string, now time.Time) *userLog {
+	if now.Sub(s.lastSweep) > s.ttl/10 {
+		for uid, l := range s.logs {
+			if now.Sub(l.touched) > s.ttl {
+				delete(s.logs, uid)
+			}
+		}
+		s.lastSweep = now
+	}
+	l := s.logs[userID]
+	if l == nil {
+		epoch, _ := uuid.NewV4()
+		l = &userLog{epoch: epoch.String(), ring: make([]*models.Message, s.size)}
+		s.logs[userID] = l
+	}
+	l.touched = now
+	return l
+}
+
+// stamp returns a copy of msg carrying the user's next sequence number and
+// records it for replay.
+func (s *sequencer) stamp(userID string, msg *models.Message) *models.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.logLocked(userID, time.Now())
+	l.last++
+	m := *msg
+	m.Seq = l.last
+	l.ring[l.last%uint64(len(l.ring))] = &m
+	if l.n < len(l.ring) {
+		l.n++
+	}
+	return &m
+}
+
+// since returns the events after cur, oldest first, and the current
+// position. ok is false when the ring does not cover the gap.
+func (s *sequencer) since(userID string, cur Cursor) (msgs []*models.Message, now Cursor, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.logLocked(userID, time.Now())
+	now = Cursor{Epoch: l.epoch, Seq: l.last}
+	oldest := l.last - uint64(l.n) + 1
+	if cur.Epoch != "" && cur.Epoch != l.epoch {
+		return nil, now, false
+	}
+	if cur.Seq > l.last || cur.Seq+1 < oldest {
+		return nil, now, false
+	}
+	for seq := cur.Seq + 1; seq <= l.last; seq++ {
+		msgs = append(msgs, l.ring[seq%uint64(len(l.ring))])
+	}
+	return msgs, now, true
+}
+
+// catchUpLocked brings a newly ready socket up to date: it replays from
+// c.Resume when set, otherwise flushes the offline queue. Codec clients
+// that cannot take frames yet are left for a later call. Callers must hold
+// h.mu and c's shard lock for writing.
+func (h *Hub) catchUpLocked(c *Client) {
+	if c.Resume == nil {
+		h.flushOfflineLocked(c)
+		return
+	}
+	if h.replayLocked(c, *c.Resume) {
+		c.Resume = nil
+	}
+}
+
+// replayLocked sends the events after cur to c, or a resync event when
+// they are gone, and reports whether c could take frames at all.
+func (h *Hub) replayLocked(c *Client, cur Cursor) bool {
+	msgs, now, ok := h.seqs.since(c.UserID, cur)
+	if !ok {
+		if !h.queueOn(c, resyncEvent(c.UserID, now)) {
+			return false
+		}
+		log.Printf("Resync required for %s (cursor %s/%d, now %s/%d)", c.ID, cur.Epoch, cur.Seq, now.Epoch, now.Seq)
+		h.flushOfflineLocked(c)
+		return true
+	}
+
+	for i, m := range msgs {
+		if !h.queueOn(c, m) {
+			if i == 0 {
+				return false
+			}
+			// The buffer filled mid-replay; the client has to reload.
+			h.queueOn(c, resyncEvent(c.UserID, now))
+			break
+		}
+	}
+	h.trimOfflineLocked(c.UserID, now.Seq)
+	log.Printf("Replayed %d events to %s from seq %d", len(msgs), c.ID, cur.Seq)
+	return true
+}
+
+func resyncEvent(userID string, now Cursor) *models.Message {
+	return &models.Message{
+		RecipientID: userID,
+		EventType:   models.EventTypeResync,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		Metadata:    map[string]interface{}{"epoch": now.Epoch, "last_seq": now.Seq},
+	}
+}
+
+// queueOn frames msg for one socket without blocking.
+func (h *Hub) queueOn(c *Client, msg *models.Message) bool {
+	data, _ := json.Marshal(msg)
+	payload, ok := c.frame(msg, data)
+	if !ok {
+		return false
+	}
+	select {
+	case c.Send <- payload:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,232 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"go-gin-example/internal/models"
+)
+
+// ======================
+// Shards
+// ======================
+
+// Sockets are partitioned by a hash of their user ID. Each shard has its
+// own lock and its own loop for registrations and queued broadcasts, so a
+// burst on one conversation or a wave of reconnects does not stall the
+// rest. A delivery encodes its message once, before taking any shard lock,
+// and then visits the shards of its recipients one at a time.
+//
+// Lock order: h.mu (read) → shard.mu → groupMu, presence.mu, cluster.mu,
+// seqs.mu. A goroutine never holds two shard locks.
+
+// DefaultShards is the shard count of hubs built by Get.
+const DefaultShards = 32
+
+type shard struct {
+	mu      sync.RWMutex
+	clients map[string][]*Client // userID → sockets
+
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan *models.Message
+}
+
+func newShard() *shard {
+	return &shard{
+		clients:    make(map[string][]*Client),
+		register:   make(chan *Client, 256),
+		unregister: make(chan *Client, 256),
+		broadcast:  make(chan *models.Message, 1024),
+	}
+}
+
+// registeredLocked reports whether c is still one of its user's sockets.
+// Callers must hold s.mu.
+func (s *shard) registeredLocked(c *Client) bool {
+	for _, cl := range s.clients[c.UserID] {
+		if cl == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hub) shardFor(userID string) *shard {
+	f := fnv.New32a()
+	f.Write([]byte(userID))
+	return h.shards[f.Sum32()%uint32(len(h.shards))]
+}
+
+// Register queues a socket for registration on its user's shard.
+func (h *Hub) Register(c *Client) {
+	select {
+	case h.shardFor(c.UserID).register <- c:
+	case <-h.done:
+	}
+}
+
+// Unregister queues a socket for removal.
+func (h *Hub) Unregister(c *Client) {
+	select {
+	case h.shardFor(c.UserID).unregister <- c:
+	case <-h.done:
+	}
+}
+
+// Broadcast queues msg for asynchronous delivery. Messages of one
+// conversation go through the same shard loop and keep their order.
+func (h *Hub) Broadcast(msg *models.Message) {
+	select {
+	case h.shardFor(routeKey(msg)).broadcast <- msg:
+	case <-h.done:
+	}
+}
+
+// routeKey names the conversation a message belongs to: its group, or the
+// pair of users of a direct message in either direction.
+func routeKey(msg *models.Message) string {
+	if msg.GroupID != "" {
+		return "g:" + msg.GroupID
+	}
+	a, b := msg.SenderID, msg.RecipientID
+	if a > b {
+		a, b = b, a
+	}
+	return "u:" + a + "|" + b
+}
+
+func (h *Hub) runShard(ctx context.Context, s *shard) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.done:
+			return
+		case c := <-s.register:
+			h.registerClient(c)
+		case c := <-s.unregister:
+			h.unregisterClient(c)
+		case msg := <-s.broadcast:
+			h.broadcastMessage(msg)
+		}
+	}
+}
+
+// encoding is a message marshalled once for every recipient. Ephemeral
+// events are shared as is; other events get each recipient's sequence
+// number spliced into the shared encoding.
+type encoding struct {
+	msg  *models.Message
+	data []byte // without seq unless ephemeral
+}
+
+func encode(msg *models.Message) *encoding {
+	if msg.Ephemeral() {
+		data, _ := json.Marshal(msg)
+		return &encoding{msg, data}
+	}
+	m := *msg
+	m.Seq = 0
+	data, _ := json.Marshal(&m)
+	return &encoding{msg, data}
+}
+
+// forUser returns the copy of the message one user receives.
+func (e *encoding) forUser(seqs *sequencer, userID string) (*models.Message, []byte) {
+	if e.msg.Ephemeral() {
+		return e.msg, e.data
+	}
+	m := seqs.stamp(userID, e.msg)
+	return m, withSeq(e.data, m.Seq)
+}
+
+// withSeq prepends a seq field to an encoded JSON object.
+func withSeq(obj []byte, seq uint64) []byte {
+	out := make([]byte, 0, len(obj)+24)
+	out = append(out, `{"seq":`...)
+	out = strconv.AppendUint(out, seq, 10)
+	if len(obj) > 2 {
+		out = append(out, ',')
+	}
+	return append(out, obj[1:]...)
+}
+
+// deliverShardsLocked queues e on the local sockets of users, one shard at
+// a time. With queue set, recipients without a socket anywhere are stored
+// offline. Callers must hold h.mu.
+func (h *Hub) deliverShardsLocked(e *encoding, users []string, queue bool) (r DeliveryReport, stored bool) {
+	plan := make(map[*shard][]string) // recipients whose sockets live on the shard
+	recipient := make(map[string]bool, len(users))
+	for _, uid := range users {
+		if !recipient[uid] {
+			recipient[uid] = true
+			s := h.shardFor(uid)
+			plan[s] = append(plan[s], uid)
+		}
+	}
+
+	stored = true
+	for _, s := range h.shards {
+		uids := plan[s]
+		if len(uids) == 0 {
+			continue
+		}
+		s.mu.RLock()
+		for _, uid := range uids {
+			list := s.clients[uid]
+			if len(list) == 0 {
+				if queue {
+					queued, ok := h.queueOfflineLocked(uid, e.msg)
+					if queued {
+						r.Queued++
+					}
+					stored = stored && ok
+				}
+				continue
+			}
+			m, data := e.forUser(h.seqs, uid)
+			for _, c := range list {
+				h.countSend(&r, c, m, data)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return r, stored
+}
+
+func (h *Hub) countSend(r *DeliveryReport, c *Client, m *models.Message, data []byte) {
+	switch h.sendLocked(c, m, data) {
+	case sendQueued:
+		r.Sockets++
+	case sendDropped:
+		r.Dropped++
+	}
+}
+
+// deliverAll queues msg on every local socket.
+func (h *Hub) deliverAll(msg *models.Message) (DeliveryReport, error) {
+	e := encode(msg)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var r DeliveryReport
+	for _, s := range h.shards {
+		s.mu.RLock()
+		for uid, list := range s.clients {
+			m, data := e.forUser(h.seqs, uid)
+			for _, c := range list {
+				h.countSend(&r, c, m, data)
+			}
+		}
+		s.mu.RUnlock()
+	}
+	if r.Dropped > 0 {
+		return r, ErrDeliveryIncomplete
+	}
+	return r, nil
+}
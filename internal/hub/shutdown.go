@@ -0,0 +1,158 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ======================
+// Shutdown
+// ======================
+
+// A hub drains in one pass: new sockets are turned away, every
+// socket flushes what is already buffered and is closed with
+// CloseGoingAway and a reconnect hint, the hub leaves the cluster and
+// unsubscribes from the broker, and the loops exit. Chat frames that
+// arrive while draining are left unacknowledged so the broker hands them
+// to another instance.
+
+// DefaultDrainTimeout bounds the flush when Stop's context has no deadline.
+const DefaultDrainTimeout = 10 * time.Second
+
+// unregisterDrain bounds the flush of a socket that is unregistered, so a
+// final RECEIPT or ERROR frame still reaches the client.
+const unregisterDrain = time.Second
+
+// reconnectSpread spreads reconnects after a shutdown so the remaining
+// instances are not hit all at once.
+var reconnectSpread = 5 * time.Second
+
+// Draining reports whether the hub is shutting down. Handlers refuse
+// upgrades from then on.
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// Stop shuts the hub down gracefully and waits until ctx expires.
+func (h *Hub) Stop(ctx context.Context) error {
+	drainBy, ok := ctx.Deadline()
+	if !ok {
+		drainBy = time.Now().Add(DefaultDrainTimeout)
+	}
+	h.stopOnce.Do(func() { go h.shutdown(drainBy) })
+
+	select {
+	case <-h.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hub) shutdown(drainBy time.Time) {
+	h.draining.Store(true)
+
+	clients := h.allClients()
+	log.Printf("Hub draining: closing %d sockets", len(clients))
+	for _, c := range clients {
+		c.release(goingAway(), drainBy)
+	}
+	for _, c := range clients {
+		if !c.pumping.Load() {
+			continue
+		}
+		select {
+		case <-c.pumpDone:
+		case <-time.After(time.Until(drainBy)):
+		}
+	}
+
+	if h.cluster != nil {
+		h.cluster.stop()
+	}
+	h.StopSTOMP()
+
+	h.mu.RLock()
+	started := h.started
+	h.mu.RUnlock()
+	h.closeOnce.Do(func() { close(h.done) })
+	if !started {
+		h.cleanup()
+		close(h.stopped)
+	}
+}
+
+func (h *Hub) allClients() []*Client {
+	var out []*Client
+	for _, s := range h.shards {
+		s.mu.RLock()
+		for _, list := range s.clients {
+			out = append(out, list...)
+		}
+		s.mu.RUnlock()
+	}
+	return out
+}
+
+// goingAway builds a CloseGoingAway frame whose reason tells the client how
+// long to wait before reconnecting.
+func goingAway() []byte {
+	wait := time.Second + time.Duration(rand.Int63n(int64(reconnectSpread)))
+	return websocket.FormatCloseMessage(websocket.CloseGoingAway,
+		fmt.Sprintf("server shutting down; reconnect_after_ms=%d", wait.Milliseconds()))
+}
+
+// lazy creates the channels of a Client built as a literal.
+func (c *Client) lazy() {
+	c.init.Do(func() {
+		c.quit = make(chan struct{})
+		c.pumpDone = make(chan struct{})
+	})
+}
+
+// release tells the write pump to stop: it writes what is buffered until
+// drainBy, then closeFrame when set. Only the first call counts.
+func (c *Client) release(closeFrame []byte, drainBy time.Time) {
+	c.lazy()
+	c.quitOnce.Do(func() {
+		c.closeFrame = closeFrame
+		c.drainBy = drainBy
+		close(c.quit)
+	})
+}
+
+// released reports whether the hub let go of c.
+func (c *Client) released() bool {
+	c.lazy()
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// flush writes the frames still buffered until the drain deadline, then the
+// close frame.
+func (c *Client) flush() {
+	for time.Now().Before(c.drainBy) {
+		select {
+		case msg := <-c.Send:
+			c.Conn.SetWriteDeadline(c.drainBy)
+			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+			continue
+		default:
+		}
+		break
+	}
+	if c.closeFrame != nil {
+		_ = c.Conn.WriteControl(websocket.CloseMessage, c.closeFrame, time.Now().Add(time.Second))
+	}
+}
@@ -0,0 +1,245 @@
+package hub
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// ======================
+// Slow Consumers
+// ======================
+
+// A socket whose Send buffer is full is a slow consumer. What happens to
+// the frame that does not fit depends on the socket's policy, chosen per
+// endpoint. Every overflow is counted per user.
+
+type SlowConsumerPolicy string
+
+const (
+	SlowDrop       SlowConsumerPolicy = "drop"        // drop the new frame
+	SlowDropOldest SlowConsumerPolicy = "drop-oldest" // evict the oldest queued frame
+	SlowDisconnect SlowConsumerPolicy = "disconnect"  // close with CloseSlowConsumer
+	SlowSpill      SlowConsumerPolicy = "spill"       // park frames in the offline store until the buffer drains
+)
+
+// Endpoints a policy can be set for.
+const (
+	EndpointWS    = "ws"
+	EndpointSTOMP = "stomp"
+)
+
+// CloseSlowConsumer is the WebSocket close code sent to disconnected slow
+// consumers. Clients should reconnect with last_seq to replay the gap.
+const CloseSlowConsumer = 4008
+
+// slowStatsMax bounds the users counted at once.
+const slowStatsMax = 10000
+
+// SlowConsumerStats counts overflows for one user.
+type SlowConsumerStats struct {
+	Dropped       uint64 `json:"dropped"`        // new frames dropped
+	DroppedOldest uint64 `json:"dropped_oldest"` // queued frames evicted
+	Spilled       uint64 `json:"spilled"`        // frames parked in the offline store
+	Disconnects   uint64 `json:"disconnects"`    // sockets closed as slow
+}
+
+type slowStats struct {
+	mu       sync.Mutex
+	users    map[string]*SlowConsumerStats
+	policies map[string]SlowConsumerPolicy // endpoint → policy
+}
+
+func newSlowStats() *slowStats {
+	return &slowStats{
+		users:    make(map[string]*SlowConsumerStats),
+		policies: make(map[string]SlowConsumerPolicy),
+	}
+}
+
+func (s *slowStats) record(userID string, fn func(*SlowConsumerStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.users[userID]
+	if st == nil {
+		if len(s.users) >= slowStatsMax {
+			// Users come and go; start over rather than grow without
+			// bound.
+			s.users = make(map[string]*SlowConsumerStats)
+		}
+		st = &SlowConsumerStats{}
+		s.users[userID] = st
+	}
+	fn(st)
+}
+
+// SetSlowConsumerPolicy sets the policy for sockets of an endpoint.
+func (h *Hub) SetSlowConsumerPolicy(endpoint string, p SlowConsumerPolicy) {
+	h.slow.mu.Lock()
+	defer h.slow.mu.Unlock()
+	h.slow.policies[endpoint] = p
+}
+
+// SlowConsumerPolicy returns the policy for an endpoint, SlowDrop if unset.
+func (h *Hub) SlowConsumerPolicy(endpoint string) SlowConsumerPolicy {
+	h.slow.mu.Lock()
+	defer h.slow.mu.Unlock()
+	if p, ok := h.slow.policies[endpoint]; ok {
+		return p
+	}
+	return SlowDrop
+}
+
+// SlowConsumerStats returns the overflow counters of every user that
+// overflowed, keyed by user ID.
+func (h *Hub) SlowConsumerStats() map[string]SlowConsumerStats {
+	h.slow.mu.Lock()
+	defer h.slow.mu.Unlock()
+	out := make(map[string]SlowConsumerStats, len(h.slow.users))
+	for uid, st := range h.slow.users {
+		out[uid] = *st
+	}
+	return out
+}
+
+// SlowestUsers returns up to n user IDs with the most overflows.
+func (h *Hub) SlowestUsers(n int) []string {
+	stats := h.SlowConsumerStats()
+	total := func(st SlowConsumerStats) uint64 {
+		return st.Dropped + st.DroppedOldest + st.Spilled + st.Disconnects
+	}
+	users := make([]string, 0, len(stats))
+	for uid := range stats {
+		users = append(users, uid)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		ti, tj := total(stats[users[i]]), total(stats[users[j]])
+		if ti != tj {
+			return ti > tj
+		}
+		return users[i] < users[j]
+	})
+	if len(users) > n {
+		users = users[:n]
+	}
+	return users
+}
+
+// overflow applies c's policy to a frame that did not fit and reports
+// whether the frame is still on its way (queued or replayable) rather than
+// lost. Callers must hold h.mu and c's shard lock.
+func (h *Hub) overflow(c *Client, msg *models.Message, payload []byte) bool {
+	policy := c.SlowPolicy
+	if policy == SlowSpill && (h.offline == nil || msg.Ephemeral()) {
+		policy = SlowDropOldest
+	}
+
+	switch policy {
+	case SlowDropOldest:
+		select {
+		case <-c.Send:
+		default:
+		}
+		select {
+		case c.Send <- payload:
+			h.slow.record(c.UserID, func(st *SlowConsumerStats) { st.DroppedOldest++ })
+			return true
+		default:
+		}
+	case SlowSpill:
+		c.spilling.Store(true)
+		return h.spill(c, msg)
+	case SlowDisconnect:
+		if c.closeSlow() {
+			h.slow.record(c.UserID, func(st *SlowConsumerStats) { st.Disconnects++ })
+		}
+		// Non-ephemeral events are in the replay ring for the reconnect.
+		return !msg.Ephemeral()
+	}
+
+	h.slow.record(c.UserID, func(st *SlowConsumerStats) { st.Dropped++ })
+	log.Printf("Buffer full: dropping msg for %s", c.UserID)
+	return false
+}
+
+// spillKey is the offline store key of one socket's spilled frames.
+func spillKey(c *Client) string {
+	return "spill:" + c.ID
+}
+
+func (h *Hub) spill(c *Client, msg *models.Message) bool {
+	if err := h.offline.Push(spillKey(c), msg); err != nil {
+		log.Printf("Spill for %s failed: %v", c.ID, err)
+		return false
+	}
+	h.slow.record(c.UserID, func(st *SlowConsumerStats) { st.Spilled++ })
+	return true
+}
+
+// drainSpill moves spilled frames back into c's buffer once it emptied and
+// ends spilling when none are left. Taking c's shard lock for writing keeps
+// live deliveries, which spill while c is spilling, from overtaking them.
+func (h *Hub) drainSpill(c *Client) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := h.shardFor(c.UserID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h.offline == nil {
+		c.spilling.Store(false)
+		return
+	}
+	key := spillKey(c)
+	msgs, err := h.offline.Pending(key)
+	if err != nil {
+		return
+	}
+	n := 0
+	for _, m := range msgs {
+		if !h.queueOn(c, m) {
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		_ = h.offline.Remove(key, n)
+	}
+	if n == len(msgs) {
+		c.spilling.Store(false)
+	}
+}
+
+// dropSpillLocked forgets an unregistered socket's spilled frames; the
+// client replays them through its cursor. Callers must hold h.mu and c's
+// shard lock.
+func (h *Hub) dropSpillLocked(c *Client) {
+	if h.offline == nil || !c.spilling.Load() {
+		return
+	}
+	if msgs, err := h.offline.Pending(spillKey(c)); err == nil && len(msgs) > 0 {
+		_ = h.offline.Remove(spillKey(c), len(msgs))
+	}
+}
+
+// closeSlow closes the socket once with CloseSlowConsumer and reports
+// whether this call did. The read pump then fails and unregisters the client.
+func (c *Client) closeSlow() (closed bool) {
+	c.slowOnce.Do(func() {
+		closed = true
+		log.Printf("Slow consumer %s (UserID=%s): disconnecting", c.ID, c.UserID)
+		if c.Conn == nil {
+			return
+		}
+		go func() {
+			msg := websocket.FormatCloseMessage(CloseSlowConsumer, "slow consumer, reconnect with last_seq")
+			_ = c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			c.Conn.Close()
+		}()
+	})
+	return closed
+}
@@ -3,6 +3,7 @@ package models
 // Message represents a chat message from Kafka
 type Message struct {
 	ID             string                 `json:"id"`
+	TargetID       string                 `json:"target_id,omitempty"` // message an edit, delete or read refers to
 	ConversationID string                 `json:"conversation_id"`
 	SenderID       string                 `json:"sender_id"`
 	RecipientID    string                 `json:"recipient_id,omitempty"`
@@ -11,24 +12,68 @@ type Message struct {
 	MessageType    string                 `json:"message_type"`
 	Metadata       map[string]interface{} `json:"metadata,omitempty"`
 	CreatedAt      string                 `json:"created_at"`
-	EventType      string                 `json:"event_type"` // message.sent, message.edited, message.deleted, etc.
+	EventType      string                 `json:"event_type"`             // message.sent, message.edited, message.deleted, etc.
+	Seq            uint64                 `json:"seq,omitempty"`          // per-recipient sequence number, set on delivery
+	Redeliveries   int                    `json:"redeliveries,omitempty"` // deliveries the recipient did not acknowledge
 }
 
 // EventType constants
 const (
-	EventTypeSent    = "message.sent"
-	EventTypeEdited  = "message.edited"
-	EventTypeDeleted = "message.deleted"
-	EventTypeRead    = "message.read"
-	EventTypeTyping  = "typing.start"
+	EventTypeSent       = "message.sent"
+	EventTypeEdited     = "message.edited"
+	EventTypeDeleted    = "message.deleted"
+	EventTypeRead       = "message.read"
+	EventTypeTyping     = "typing.start"
 	EventTypeStopTyping = "typing.stop"
+
+	EventTypePresenceOnline      = "presence.online"
+	EventTypePresenceOffline     = "presence.offline"
+	EventTypePresenceSubscribe   = "presence.subscribe"   // metadata.user_ids: contacts to watch
+	EventTypePresenceUnsubscribe = "presence.unsubscribe" // metadata.user_ids: contacts to stop watching
+
+	EventTypeSystemNotice = "system.notice"
+	EventTypeResync       = "session.resync" // metadata.epoch, metadata.last_seq: reload, the gap cannot be replayed
 )
 
+// Ephemeral reports whether the event is a transient signal (typing, read
+// receipt, presence, system notice, resync) that must not be persisted or
+// retried.
+func (m *Message) Ephemeral() bool {
+	switch m.EventType {
+	case EventTypeTyping, EventTypeStopTyping, EventTypeRead,
+		EventTypePresenceOnline, EventTypePresenceOffline, EventTypeSystemNotice, EventTypeResync:
+		return true
+	}
+	return false
+}
+
 // WelcomeMessage represents a welcome message sent to newly connected clients
 type WelcomeMessage struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
 	UserID  string `json:"user_id"`
 	Time    string `json:"time"`
+	Epoch   string `json:"epoch,omitempty"` // resume epoch, sent back with last_seq
+
+	ConnectionID string `json:"connection_id"`
+}
+
+// AckMessage confirms to the sender that an inbound message was accepted
+type AckMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ErrorMessage tells the sender why an inbound message was rejected
+type ErrorMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
+// PresenceStatus reports whether a user currently has a live socket
+type PresenceStatus struct {
+	UserID   string `json:"user_id"`
+	Online   bool   `json:"online"`
+	LastSeen string `json:"last_seen,omitempty"`
+}
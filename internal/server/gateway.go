@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/hmac"
+	"errors"
+	"go-gin-example/internal/config"
+	"go-gin-example/internal/helper"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	errUntrustedPeer    = errors.New("identity header from an untrusted peer")
+	errUnsignedIdentity = errors.New("identity header without a valid signature")
+	errStaleSignature   = errors.New("identity signature expired")
+)
+
+// gatewayTrust verifies that identity headers were set by our gateway.
+type gatewayTrust struct {
+	proxies []*net.IPNet
+	secret  []byte
+	maxSkew time.Duration
+}
+
+func newGatewayTrust(cfg config.Gateway) *gatewayTrust {
+	g := &gatewayTrust{secret: []byte(cfg.Secret), maxSkew: cfg.MaxSkew}
+	for _, cidr := range cfg.TrustedProxies {
+		if _, n, err := net.ParseCIDR(cidr); err == nil { // validated by config
+			g.proxies = append(g.proxies, n)
+		}
+	}
+	return g
+}
+
+// configured reports whether any check is set up.
+func (g *gatewayTrust) configured() bool {
+	return len(g.proxies) > 0 || len(g.secret) > 0
+}
+
+// verify checks every configured requirement for userID. The peer is the
+// TCP address, never a forwarded header a client could forge.
+func (g *gatewayTrust) verify(r *http.Request, userID string, now time.Time) error {
+	if len(g.proxies) > 0 && !g.trustedPeer(r.RemoteAddr) {
+		return errUntrustedPeer
+	}
+	if len(g.secret) == 0 {
+		return nil
+	}
+	ts := r.Header.Get("X-Gateway-Timestamp")
+	sig := r.Header.Get("X-Gateway-Signature")
+	want := helper.SignGatewayIdentity(string(g.secret), userID, ts)
+	if ts == "" || !hmac.Equal([]byte(sig), []byte(want)) {
+		return errUnsignedIdentity
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errUnsignedIdentity
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > g.maxSkew || skew < -g.maxSkew {
+		return errStaleSignature
+	}
+	return nil
+}
+
+func (g *gatewayTrust) trustedPeer(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range g.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-gin-example/internal/config"
+	"go-gin-example/internal/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGatewayIdentityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "gateway-secret"
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	signed := func(ts string) map[string]string {
+		return map[string]string{
+			"X-Gateway-Timestamp": ts,
+			"X-Gateway-Signature": helper.SignGatewayIdentity(secret, "alice", ts),
+		}
+	}
+
+	cases := []struct {
+		name    string
+		gateway config.Gateway
+		peer    string
+		headers map[string]string
+		want    int
+	}{
+		{"no trust configured", config.Gateway{}, "10.0.0.5:4000", nil, http.StatusUnauthorized},
+		{"trusted proxy", config.Gateway{TrustedProxies: []string{"10.0.0.0/8"}}, "10.0.0.5:4000", nil, http.StatusOK},
+		{"untrusted peer", config.Gateway{TrustedProxies: []string{"10.0.0.0/8"}}, "203.0.113.9:4000", nil, http.StatusUnauthorized},
+		{"signed", config.Gateway{Secret: secret, MaxSkew: time.Minute}, "203.0.113.9:4000", signed(now), http.StatusOK},
+		{"unsigned", config.Gateway{Secret: secret, MaxSkew: time.Minute}, "203.0.113.9:4000", nil, http.StatusUnauthorized},
+		{"stale", config.Gateway{Secret: secret, MaxSkew: time.Minute}, "203.0.113.9:4000", signed(stale), http.StatusUnauthorized},
+		{"signed for someone else", config.Gateway{Secret: secret, MaxSkew: time.Minute}, "203.0.113.9:4000",
+			map[string]string{"X-Gateway-Timestamp": now, "X-Gateway-Signature": helper.SignGatewayIdentity(secret, "bob", now)}, http.StatusUnauthorized},
+		{"signed from untrusted peer", config.Gateway{TrustedProxies: []string{"10.0.0.0/8"}, Secret: secret, MaxSkew: time.Minute}, "203.0.113.9:4000", signed(now), http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{}
+			r := gin.New()
+			r.Use(Authenticated(config.Auth{Strict: true, Gateway: tc.gateway}))
+			r.GET("/me", RequireAuth(), s.WhoamiHandler)
+
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.RemoteAddr = tc.peer
+			req.Header.Set("X-Ws-User-Id", "alice")
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+		})
+	}
+}
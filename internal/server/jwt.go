@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"go-gin-example/internal/config"
+	"go-gin-example/internal/constants"
+	"go-gin-example/internal/helper"
+
+	"github.com/gofrs/uuid"
+)
+
+var (
+	errNoSubject    = errors.New("token names no user")
+	errRefreshToken = errors.New("refresh token used as an access token")
+)
+
+// localVerifier accepts the HS512 tokens issued by /signin under any of
+// secrets, so a rotated-out secret keeps verifying until it is dropped.
+// Revoked tokens are refused when revocations is set.
+func localVerifier(secrets []string, revocations helper.RevocationStore) *helper.JwtVerifier {
+	keys := make([]helper.KeyProvider, len(secrets))
+	for i, s := range secrets {
+		keys[i] = helper.HMACKey(s)
+	}
+	return &helper.JwtVerifier{
+		Keys:        helper.KeyProviders(keys...),
+		Algorithms:  []string{"HS512"},
+		Revocations: revocations,
+	}
+}
+
+// newVerifiers returns the verifiers tried in turn on a token: the local
+// one and, when configured, the identity provider's.
+func newVerifiers(auth config.Auth, revocations helper.RevocationStore) ([]*helper.JwtVerifier, error) {
+	verifiers := []*helper.JwtVerifier{localVerifier(auth.Secrets, revocations)}
+	cfg := auth.JWT
+	if !cfg.Enabled() {
+		return verifiers, nil
+	}
+
+	var providers []helper.KeyProvider
+	if len(cfg.PublicKeys) > 0 {
+		keys, err := helper.LoadPEMKeys(cfg.PublicKeys...)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, keys)
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := helper.LoadJWKSFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, keys)
+	}
+	if cfg.JWKSURL != "" {
+		providers = append(providers, helper.NewJWKSURL(cfg.JWKSURL, cfg.JWKSCacheTTL))
+	}
+	return append(verifiers, &helper.JwtVerifier{
+		Keys:        helper.KeyProviders(providers...),
+		Algorithms:  cfg.Algorithms,
+		Issuer:      cfg.Issuer,
+		Audience:    cfg.Audience,
+		Revocations: revocations,
+	}), nil
+}
+
+// verifyToken returns the user an access token names and its claims,
+// trying each verifier. Refresh tokens are refused.
+func verifyToken(verifiers []*helper.JwtVerifier, token string) (string, constants.Claims, error) {
+	var err error
+	for _, v := range verifiers {
+		var claims constants.Claims
+		claims, err = helper.VerifyJwtClaim[constants.Claims](token, v)
+		if err != nil {
+			continue
+		}
+		if claims.Use == constants.TokenRefresh {
+			return "", claims, errRefreshToken
+		}
+		if claims.UserID != uuid.Nil {
+			return claims.UserID.String(), claims, nil
+		}
+		if claims.Subject != "" {
+			return claims.Subject, claims, nil
+		}
+		return "", claims, errNoSubject
+	}
+	return "", constants.Claims{}, err
+}
@@ -2,48 +2,167 @@ package server
 
 import (
 	"fmt"
+	"go-gin-example/internal/config"
 	"go-gin-example/internal/constants"
+	"go-gin-example/internal/handler"
 	"go-gin-example/internal/helper"
 	"log"
+	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
-func Authenticated() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		currentUserId := "00000000-0000-0000-0000-000000000000"
+// anonymousUserID is the identity of unauthenticated requests when strict
+// auth is off.
+const anonymousUserID = "00000000-0000-0000-0000-000000000000"
 
-		gatewayWsUserId := c.GetHeader("X-Ws-User-Id")
-		gatewayRestUserId := c.GetHeader("X-User-Id")
+// Authenticated resolves the caller from the gateway headers or a JWT and
+// sets "user_id". Gateway headers count only when they pass the configured
+// gateway checks; a forged or stale one is rejected with 401. When the
+// caller cannot be identified, strict leaves "user_id" unset so RequireAuth
+// answers 401; otherwise the request runs as the anonymous user. Tokens are
+// checked by verifiers in turn, by default the local HS512 one without
+// revocation checks.
+func Authenticated(auth config.Auth, verifiers ...*helper.JwtVerifier) gin.HandlerFunc {
+	gateway := newGatewayTrust(auth.Gateway)
+	if len(verifiers) == 0 {
+		verifiers = []*helper.JwtVerifier{localVerifier(auth.Secrets, nil)}
+	}
+	if auth.Strict && !gateway.configured() {
+		log.Println("No gateway trust configured: ignoring identity headers")
+	}
 
-		log.Printf("--- X-Ws-User-Id: %v", gatewayWsUserId)
-		log.Printf("--- X-User-Id: %v", gatewayRestUserId)
+	return func(c *gin.Context) {
+		gatewayUserId, source := c.GetHeader("X-Ws-User-Id"), "ws"
+		if gatewayUserId == "" {
+			gatewayUserId, source = c.GetHeader("X-User-Id"), "rest"
+		}
 
-		if gatewayWsUserId != "" {
-			log.Printf("Gateway ws authenticated user %v", gatewayWsUserId)
-			currentUserId = gatewayWsUserId
-		} else if gatewayRestUserId != "" {
-			log.Printf("Gateway rest authenticated user %v", gatewayRestUserId)
-			currentUserId = gatewayRestUserId
-		} else {
-			token := c.GetHeader("Authorization")
+		if gatewayUserId != "" && (gateway.configured() || !auth.Strict) {
+			if err := gateway.verify(c.Request, gatewayUserId, time.Now()); err != nil {
+				log.Printf("Rejected gateway identity %v from %s: %v", gatewayUserId, c.Request.RemoteAddr, err)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				return
+			}
+			log.Printf("Gateway %s authenticated user %v", source, gatewayUserId)
+			c.Set("user_id", gatewayUserId)
+			c.Next()
+			return
+		}
 
-			if token != "" {
-				tokenParts := strings.Split(token, " ")
+		if token := accessToken(c.Request); token != "" {
+			userID, claims, err := verifyToken(verifiers, token)
+			if err == nil {
+				c.Set("user_id", userID)
+				c.Set("token_id", claims.ID)
+				c.Set("claims", claims)
+				c.Next()
+				return
+			}
+			log.Printf("Rejected token: %v", err)
+		}
+		if !auth.Strict {
+			c.Set("user_id", anonymousUserID)
+		}
 
-				claims, err := helper.ExtractJwtClaim[constants.Claims](tokenParts[1], constants.JwtSecret)
-				if err != nil {
-					fmt.Printf("Validate token error %v", err)
-				}
+		c.Next()
+	}
+}
 
-				currentUserId = claims.UserID.String()
+// RequireAuth answers 401 to requests Authenticated could not identify. It
+// runs before the handler, so a WebSocket upgrade is refused before it
+// happens.
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_id") == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
 
-			}
+// RequireAdmin answers 403 unless the caller is one of the configured
+// admins or its token carries the admin role. The anonymous user never is.
+func RequireAdmin(auth config.Auth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		admin := userID != "" && userID != anonymousUserID && slices.Contains(auth.Admins, userID)
+		if claims, ok := c.Get("claims"); ok && !admin {
+			admin = slices.Contains(claims.(constants.Claims).Roles, constants.RoleAdmin)
+		}
+		if !admin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
 		}
+		c.Next()
+	}
+}
 
-		c.Set("user_id", currentUserId)
+// accessToken finds the JWT in the Authorization header or, on WebSocket
+// upgrades only, the Sec-WebSocket-Protocol offer or the access_token query
+// parameter. Browsers cannot set headers on an upgrade; other requests must
+// not put tokens in URLs, which end up in access logs.
+func accessToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		return ""
+	}
+	var offered []string
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			offered = append(offered, strings.TrimSpace(p))
+		}
+	}
+	for i, p := range offered {
+		if p == handler.AuthSubprotocol && i+1 < len(offered) {
+			return offered[i+1]
+		}
+	}
+	return r.URL.Query().Get("access_token")
+}
 
-		c.Next()
+// logFormatter is gin's default request log line with the access_token
+// query parameter redacted.
+func logFormatter(p gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if p.IsOutputColor() {
+		statusColor, methodColor, resetColor = p.StatusCodeColor(), p.MethodColor(), p.ResetColor()
+	}
+	if p.Latency > time.Minute {
+		p.Latency = p.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, p.StatusCode, resetColor,
+		p.Latency,
+		p.ClientIP,
+		methodColor, p.Method, resetColor,
+		redactQuery(p.Path),
+		p.ErrorMessage,
+	)
+}
+
+// redactQuery hides the access_token parameter of a request path.
+func redactQuery(path string) string {
+	base, raw, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return base + "?REDACTED"
+	}
+	if !q.Has("access_token") {
+		return path
 	}
+	q.Set("access_token", "REDACTED")
+	return base + "?" + q.Encode()
 }
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gin-example/internal/config"
+	"go-gin-example/internal/constants"
+	"go-gin-example/internal/handler"
+	"go-gin-example/internal/helper"
+	"go-gin-example/internal/hub"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const testSecret = "test-secret-test-secret-test-secret"
+
+func TestStrictAuthOnUpgrades(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := hub.New(hub.Options{})
+	h.Start(t.Context())
+	defer h.Stop(t.Context())
+	s := &Server{auth: config.Auth{Strict: true, Secrets: []string{testSecret}}, hub: h, handler: handler.New(h)}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws-chat/ws"
+
+	userID, _ := uuid.NewV4()
+	token, err := helper.SignJwt(constants.Claims{UserID: userID}, testSecret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refused := map[string]http.Header{
+		"no token":        nil,
+		"invalid token":   {"Authorization": {"Bearer " + token + "x"}},
+		"UserID header":   {"UserID": {userID.String()}},
+		"token not after": {"Sec-WebSocket-Protocol": {"access_token"}},
+	}
+	for name, header := range refused {
+		_, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: upgrade not refused with 401 (%v)", name, err)
+		}
+	}
+
+	accepted := map[string]struct {
+		url    string
+		header http.Header
+	}{
+		"header":      {url, http.Header{"Authorization": {"Bearer " + token}}},
+		"query":       {url + "?access_token=" + token, nil},
+		"subprotocol": {url, http.Header{"Sec-WebSocket-Protocol": {"access_token, " + token}}},
+	}
+	for name, tc := range accepted {
+		ws, resp, err := websocket.DefaultDialer.Dial(tc.url, tc.header)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if tc.header.Get("Sec-WebSocket-Protocol") != "" && resp.Header.Get("Sec-WebSocket-Protocol") != handler.AuthSubprotocol {
+			t.Errorf("%s: selected subprotocol %q", name, resp.Header.Get("Sec-WebSocket-Protocol"))
+		}
+		var welcome struct {
+			UserID string `json:"user_id"`
+		}
+		if err := ws.ReadJSON(&welcome); err != nil || welcome.UserID != userID.String() {
+			t.Errorf("%s: welcome for %q (%v)", name, welcome.UserID, err)
+		}
+		ws.Close()
+	}
+}
+
+func TestLenientAuthFallsBackToAnonymous(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{}
+	r := gin.New()
+	r.Use(Authenticated(config.Auth{}))
+	r.GET("/me", s.WhoamiHandler)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/me", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), anonymousUserID) {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestRotatedSecretsStillVerify(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const old, current = "old-secret-old-secret-old-secret", "new-secret-new-secret-new-secret"
+	s, err := newServer(nil, config.Auth{Strict: true, Secrets: []string{current, old}, AccessTTL: time.Minute, RefreshTTL: time.Hour}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(Authenticated(s.auth))
+	r.POST("/signin", s.SignInHandler)
+	r.GET("/me", RequireAuth(), s.WhoamiHandler)
+
+	userID, _ := uuid.NewV4()
+	legacy, _ := helper.SignJwt(constants.Claims{UserID: userID}, old, time.Minute)
+	retired, _ := helper.SignJwt(constants.Claims{UserID: userID}, "retired-secret-retired-secret-xx", time.Minute)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/signin", nil))
+	var signin struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &signin); err != nil || signin.AccessToken == "" {
+		t.Fatalf("signin: %d %s", rr.Code, rr.Body)
+	}
+	if _, err := helper.ExtractJwtClaim[constants.Claims](signin.AccessToken, current); err != nil {
+		t.Fatalf("signin token not signed with the current secret: %v", err)
+	}
+
+	for token, want := range map[string]int{
+		signin.AccessToken: http.StatusOK,
+		legacy:             http.StatusOK,
+		retired:            http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("status = %d, want %d: %s", rr.Code, want, rr.Body)
+		}
+	}
+}
+
+func TestAdminRoutesNeedAnAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := hub.New(hub.Options{})
+	admin, _ := uuid.NewV4()
+	user, _ := uuid.NewV4()
+	sign := func(userID uuid.UUID, roles ...string) string {
+		token, err := helper.SignJwt(constants.Claims{UserID: userID, Roles: roles}, testSecret, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	for name, tc := range map[string]struct {
+		strict bool
+		token  string
+		want   int
+	}{
+		"configured admin": {true, sign(admin), http.StatusOK},
+		"admin role":       {true, sign(user, constants.RoleAdmin), http.StatusOK},
+		"plain user":       {true, sign(user), http.StatusForbidden},
+		"anonymous":        {false, "", http.StatusForbidden},
+	} {
+		s := &Server{
+			auth: config.Auth{Strict: tc.strict, Secrets: []string{testSecret}, Admins: []string{admin.String(), anonymousUserID}},
+			hub:  h, handler: handler.New(h),
+		}
+		routes := s.RegisterRoutes()
+		for _, path := range []string{"/ws-chat/admin/connections", "/ws-chat/admin/metrics/slow-consumers"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, req)
+			if rr.Code != tc.want {
+				t.Errorf("%s %s: status %d, want %d", name, path, rr.Code, tc.want)
+			}
+		}
+	}
+}
+
+func TestQueryTokenOnlyOnUpgrades(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID, _ := uuid.NewV4()
+	token, _ := helper.SignJwt(constants.Claims{UserID: userID}, testSecret, time.Minute)
+	s := &Server{auth: config.Auth{Strict: true, Secrets: []string{testSecret}}}
+	r := gin.New()
+	r.Use(Authenticated(s.auth))
+	r.GET("/me", RequireAuth(), s.WhoamiHandler)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/me?access_token="+token, nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("query token accepted outside an upgrade: %d", rr.Code)
+	}
+
+	logged := redactQuery("/ws-chat/ws?room_id=g1&access_token=" + token)
+	if strings.Contains(logged, token) || !strings.Contains(logged, "room_id=g1") {
+		t.Fatalf("logged %q", logged)
+	}
+}
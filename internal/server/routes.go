@@ -2,16 +2,11 @@ package server
 
 import (
 	"net/http"
-	"time"
 
 	_ "go-gin-example/docs"
-	"go-gin-example/internal/constants"
-	"go-gin-example/internal/handler"
-	"go-gin-example/internal/helper"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/gofrs/uuid"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -37,8 +32,13 @@ import (
 // YOUR HANDLERS
 // ──────────────────────────────────────────────────────────────
 func (s *Server) RegisterRoutes() http.Handler {
-	r := gin.Default()
-	r.Use(Authenticated())
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(logFormatter), gin.Recovery())
+	if proxies := s.auth.Gateway.TrustedProxies; len(proxies) > 0 {
+		// Only the gateway may set X-Forwarded-For for ClientIP.
+		_ = r.SetTrustedProxies(proxies)
+	}
+	r.Use(Authenticated(s.auth, s.tokens...))
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
@@ -48,41 +48,43 @@ func (s *Server) RegisterRoutes() http.Handler {
 	}))
 
 	r.POST("/ws-chat/signin", s.SignInHandler)
+	r.POST("/ws-chat/token/refresh", s.RefreshHandler)
+	// Public so a client whose access token expired can still sign out with
+	// its refresh token.
+	r.POST("/ws-chat/signout", s.SignOutHandler)
 
-	r.GET("/ws-chat/me", s.WhoamiHandler)
+	r.GET("/ws-chat/health", s.handler.HealthHandler)
 
-	r.GET("/ws-chat/ws", handler.WsHandler)
+	r.GET("/ws-chat/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	r.GET("/ws-chat/stomp/connect", handler.StompHandler)
+	// Everything else needs an identity; upgrades are refused before they happen.
+	auth := r.Group("/", RequireAuth())
 
-	r.GET("/ws-chat/stomp/send-private-message", handler.SendStompPrivateHandler)
+	auth.GET("/ws-chat/me", s.WhoamiHandler)
 
-	r.GET("/ws-chat/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	auth.GET("/ws-chat/ws", s.handler.WsHandler)
 
-	return r
-}
+	auth.GET("/ws-chat/stomp/connect", s.handler.StompHandler)
 
-// SignInHandler godoc
-// @Summary      Sign in and get JWT
-// @Description  Generates a JWT token with a random user ID (demo only)
-// @Tags         auth
-// @Accept       json
-// @Produce      json
-// @Success      200  {object}  map[string]string  "access_token"
-// @Failure      500  {object}  map[string]string  "error"
-// @Router       /signin [post]
-func (s *Server) SignInHandler(c *gin.Context) {
-	userId, _ := uuid.NewV4()
-	jwtBody := constants.Claims{UserID: userId}
-	if token, err := helper.SignJwt(jwtBody, constants.JwtSecret, 30*time.Minute); err == nil {
-		resp := make(map[string]string)
-		resp["access_token"] = token
-
-		c.JSON(http.StatusOK, resp)
-	} else {
-		c.JSON(http.StatusInternalServerError, err)
-	}
+	auth.POST("/ws-chat/stomp/publish", s.handler.PublishHandler)
+
+	auth.POST("/ws-chat/messages", s.handler.SendMessageHandler)
+
+	auth.POST("/ws-chat/groups", s.handler.CreateGroupHandler)
+	auth.GET("/ws-chat/groups/:group_id/members", s.handler.GroupMembersHandler)
+	auth.POST("/ws-chat/groups/:group_id/members", s.handler.JoinGroupHandler)
+	auth.DELETE("/ws-chat/groups/:group_id/members", s.handler.LeaveGroupHandler)
 
+	auth.GET("/ws-chat/presence", s.handler.PresenceHandler)
+
+	admin := auth.Group("/ws-chat/admin", RequireAdmin(s.auth))
+	admin.GET("/connections", s.handler.ConnectionsHandler)
+	admin.GET("/connections/:connection_id", s.handler.ConnectionHandler)
+	admin.POST("/connections/:connection_id/messages", s.handler.ConnectionMessageHandler)
+	admin.DELETE("/connections/:connection_id", s.handler.DisconnectHandler)
+	admin.GET("/metrics/slow-consumers", s.handler.SlowConsumersHandler)
+
+	return r
 }
 
 // WhoamiHandler godoc
@@ -1,33 +1,193 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"go-gin-example/internal/config"
+	"go-gin-example/internal/constants"
+	"go-gin-example/internal/helper"
+	"go-gin-example/internal/hub"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
-func TestHelloWorldHandler(t *testing.T) {
-	s := &Server{}
-	r := gin.New()
-	r.GET("/", s.SignInHandler)
-	// Create a test HTTP request
-	req, err := http.NewRequest("GET", "/", nil)
+func newTokenServer(t *testing.T, opts Options) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	h := hub.New(hub.Options{})
+	h.Start(t.Context())
+	t.Cleanup(func() { h.Stop(t.Context()) })
+	s, err := newServer(h, config.Auth{Strict: true, Secrets: []string{testSecret}, AccessTTL: time.Minute, RefreshTTL: time.Hour}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.RegisterRoutes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// call sends a request with an optional bearer token and JSON body and
+// decodes a token pair from the answer, if any.
+func call(t *testing.T, method, url, token string, body any) (int, tokenPair) {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, url, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var pair tokenPair
+	json.NewDecoder(resp.Body).Decode(&pair)
+	return resp.StatusCode, pair
+}
+
+func refresh(t *testing.T, srv *httptest.Server, token string) (int, tokenPair) {
+	t.Helper()
+	return call(t, http.MethodPost, srv.URL+"/ws-chat/token/refresh", "", refreshRequest{RefreshToken: token})
+}
+
+func TestSignInRefreshAndSignOut(t *testing.T) {
+	revocations := helper.NewMemoryRevocations()
+	srv := newTokenServer(t, Options{Revocations: revocations})
+	me := srv.URL + "/ws-chat/me"
+
+	code, first := call(t, http.MethodPost, srv.URL+"/ws-chat/signin", "", nil)
+	if code != http.StatusOK || first.AccessToken == "" || first.RefreshToken == "" || first.ExpiresIn != 60 {
+		t.Fatalf("signin: %d %+v", code, first)
+	}
+	if code, _ := call(t, http.MethodGet, me, first.AccessToken, nil); code != http.StatusOK {
+		t.Fatalf("access token: %d", code)
+	}
+	if code, _ := call(t, http.MethodGet, me, first.RefreshToken, nil); code != http.StatusUnauthorized {
+		t.Fatalf("refresh token accepted as an access token: %d", code)
+	}
+
+	code, second := refresh(t, srv, first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh: %d %+v", code, second)
+	}
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws-chat/ws?access_token=" + second.AccessToken
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var welcome map[string]any
+	if err := ws.ReadJSON(&welcome); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := call(t, http.MethodPost, srv.URL+"/ws-chat/signout", second.AccessToken, nil); code != http.StatusNoContent {
+		t.Fatalf("signout: %d", code)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err = ws.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, hub.CloseTokenRevoked) {
+		t.Fatalf("socket not closed as revoked: %v", err)
+	}
+
+	for _, token := range []string{first.AccessToken, second.AccessToken} {
+		if code, _ := call(t, http.MethodGet, me, token, nil); code != http.StatusUnauthorized {
+			t.Errorf("access token of an ended session: %d", code)
+		}
+	}
+	claims, err := helper.ExtractJwtClaim[constants.Claims](second.AccessToken, testSecret)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// Create a ResponseRecorder to record the response
-	rr := httptest.NewRecorder()
-	// Serve the HTTP request
-	r.ServeHTTP(rr, req)
-	// Check the status code
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
-	}
-	// Check the response body
-	expected := "{\"message\":\"Hello World\"}"
-	if rr.Body.String() != expected {
-		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	if revoked, _ := revocations.Revoked(claims.ID); !revoked {
+		t.Error("signout did not record the access token in the server's store")
+	}
+	if code, _ := refresh(t, srv, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh after signout: %d", code)
+	}
+}
+
+func TestSessionsAreSharedThroughTheStores(t *testing.T) {
+	opts := Options{Sessions: helper.NewMemorySessions(), Revocations: helper.NewMemoryRevocations()}
+	a, b := newTokenServer(t, opts), newTokenServer(t, opts)
+
+	_, first := call(t, http.MethodPost, a.URL+"/ws-chat/signin", "", nil)
+	code, second := refresh(t, b, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh on another server: %d", code)
+	}
+	if code, _ := call(t, http.MethodPost, b.URL+"/ws-chat/signout", second.AccessToken, nil); code != http.StatusNoContent {
+		t.Fatalf("signout: %d", code)
+	}
+	if code, _ := call(t, http.MethodGet, a.URL+"/ws-chat/me", second.AccessToken, nil); code != http.StatusUnauthorized {
+		t.Errorf("token revoked on another server still accepted: %d", code)
+	}
+
+	if code, _ := refresh(t, newTokenServer(t, Options{}), second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("a server with its own stores refreshed a foreign session: %d", code)
+	}
+}
+
+func TestClusteringNeedsSharedStores(t *testing.T) {
+	cfg := &config.Config{Port: 8080, Cluster: config.Cluster{Enabled: true}, Auth: config.Auth{Secrets: []string{testSecret}}}
+	for name, opts := range map[string]Options{
+		"default":   {},
+		"in memory": {Sessions: helper.NewMemorySessions(), Revocations: helper.NewMemoryRevocations()},
+	} {
+		if _, err := NewServer(nil, cfg, opts); !errors.Is(err, errMemoryStores) {
+			t.Errorf("%s stores with clustering on: %v", name, err)
+		}
+	}
+	cfg.Cluster.Enabled = false
+	if _, err := NewServer(nil, cfg, Options{}); err != nil {
+		t.Errorf("single replica: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	srv := newTokenServer(t, Options{})
+
+	_, first := call(t, http.MethodPost, srv.URL+"/ws-chat/signin", "", nil)
+	code, second := refresh(t, srv, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: %d", code)
+	}
+
+	if code, _ := refresh(t, srv, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: %d", code)
+	}
+	if code, _ := call(t, http.MethodGet, srv.URL+"/ws-chat/me", second.AccessToken, nil); code != http.StatusUnauthorized {
+		t.Errorf("access token of a revoked session: %d", code)
+	}
+	if code, _ := refresh(t, srv, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token of a revoked session: %d", code)
+	}
+
+	// Signing out with only the refresh token works once the access token
+	// is gone.
+	_, third := call(t, http.MethodPost, srv.URL+"/ws-chat/signin", "", nil)
+	if code, _ := call(t, http.MethodPost, srv.URL+"/ws-chat/signout", "", refreshRequest{RefreshToken: third.RefreshToken}); code != http.StatusNoContent {
+		t.Fatalf("signout with refresh token: %d", code)
+	}
+	if code, _ := call(t, http.MethodGet, srv.URL+"/ws-chat/me", third.AccessToken, nil); code != http.StatusUnauthorized {
+		t.Errorf("access token after signout: %d", code)
 	}
 }
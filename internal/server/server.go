@@ -1,24 +1,50 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
+	"go-gin-example/internal/config"
+	"go-gin-example/internal/handler"
+	"go-gin-example/internal/helper"
+	"go-gin-example/internal/hub"
+
 	_ "github.com/joho/godotenv/autoload"
 )
 
 type Server struct {
-	port int
+	port        int
+	auth        config.Auth
+	tokens      []*helper.JwtVerifier
+	sessions    helper.SessionStore
+	revocations helper.RevocationStore
+	hub         *hub.Hub
+	handler     *handler.Handler
+}
+
+// Options holds the stores behind the token routes. Nil stores are kept in
+// process memory, which only works with a single replica: a session
+// started on one replica cannot be refreshed or ended on another, and a
+// sign-out is not seen by the others. With clustering on, both must be
+// shared stores.
+type Options struct {
+	Sessions    helper.SessionStore
+	Revocations helper.RevocationStore
 }
 
-func NewServer() *http.Server {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	NewServer := &Server{
-		port: port,
+var errMemoryStores = errors.New("cluster.enabled: sessions and revocations need shared stores (server.Options); in-memory ones only work with a single replica")
+
+func NewServer(h *hub.Hub, cfg *config.Config, opts Options) (*http.Server, error) {
+	if cfg.Cluster.Enabled && !opts.shared() {
+		return nil, errMemoryStores
+	}
+	NewServer, err := newServer(h, cfg.Auth, opts)
+	if err != nil {
+		return nil, err
 	}
+	NewServer.port = cfg.Port
 
 	// Declare Server config
 	server := &http.Server{
@@ -29,5 +55,33 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	return server, nil
+}
+
+func newServer(h *hub.Hub, auth config.Auth, opts Options) (*Server, error) {
+	if opts.Sessions == nil {
+		opts.Sessions = helper.NewMemorySessions()
+	}
+	if opts.Revocations == nil {
+		opts.Revocations = helper.NewMemoryRevocations()
+	}
+	tokens, err := newVerifiers(auth, opts.Revocations)
+	if err != nil {
+		return nil, fmt.Errorf("load token keys: %w", err)
+	}
+	return &Server{
+		auth:        auth,
+		tokens:      tokens,
+		sessions:    opts.Sessions,
+		revocations: opts.Revocations,
+		hub:         h,
+		handler:     handler.New(h),
+	}, nil
+}
+
+// shared reports whether both stores are set and not kept in memory.
+func (o Options) shared() bool {
+	_, memSessions := o.Sessions.(*helper.MemorySessions)
+	_, memRevocations := o.Revocations.(*helper.MemoryRevocations)
+	return o.Sessions != nil && o.Revocations != nil && !memSessions && !memRevocations
 }
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"go-gin-example/internal/constants"
+	"go-gin-example/internal/helper"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+)
+
+// ======================
+// Sessions
+// ======================
+
+// sessionTokens describes the pair access, refresh for the session store.
+func sessionTokens(access, refresh constants.Claims) helper.SessionTokens {
+	return helper.SessionTokens{
+		UserID:        access.UserID,
+		Access:        access.ID,
+		AccessExpires: time.Unix(access.ExpiresAt, 0),
+		Refresh:       refresh.ID,
+		Expires:       time.Unix(refresh.ExpiresAt, 0),
+	}
+}
+
+// ======================
+// Tokens
+// ======================
+
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// signPair signs an access and a refresh token for userID in session sid.
+func (s *Server) signPair(userID uuid.UUID, sid string) (tokenPair, constants.Claims, constants.Claims, error) {
+	var access, refresh constants.Claims
+	if len(s.auth.Secrets) == 0 {
+		return tokenPair{}, access, refresh, errors.New("no signing secret configured")
+	}
+	if s.auth.AccessTTL <= 0 || s.auth.RefreshTTL <= 0 {
+		return tokenPair{}, access, refresh, errors.New("token lifetimes not configured")
+	}
+	now := time.Now()
+	claims := func(use string, ttl time.Duration) constants.Claims {
+		id, _ := uuid.NewV4()
+		return constants.Claims{
+			UserID:    userID,
+			ID:        id.String(),
+			Use:       use,
+			SessionID: sid,
+			ExpiresAt: now.Add(ttl).Unix(),
+		}
+	}
+	access = claims(constants.TokenAccess, s.auth.AccessTTL)
+	refresh = claims(constants.TokenRefresh, s.auth.RefreshTTL)
+
+	// The first secret signs; the others only verify while being rotated out.
+	secret := s.auth.Secrets[0]
+	accessToken, err := helper.SignJwt(access, secret, 0)
+	if err != nil {
+		return tokenPair{}, access, refresh, err
+	}
+	refreshToken, err := helper.SignJwt(refresh, secret, 0)
+	if err != nil {
+		return tokenPair{}, access, refresh, err
+	}
+	return tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.auth.AccessTTL.Seconds()),
+	}, access, refresh, nil
+}
+
+// refreshClaims verifies a refresh token issued by /signin.
+func (s *Server) refreshClaims(token string) (constants.Claims, error) {
+	claims, err := helper.VerifyJwtClaim[constants.Claims](token, localVerifier(s.auth.Secrets, s.revocations))
+	if err != nil {
+		return claims, err
+	}
+	if claims.Use != constants.TokenRefresh || claims.SessionID == "" {
+		return claims, errors.New("not a refresh token")
+	}
+	return claims, nil
+}
+
+// revoke records access tokens as revoked and closes the sockets opened
+// with them on every instance.
+func (s *Server) revoke(tokens map[string]time.Time) {
+	ids := make([]string, 0, len(tokens))
+	for jti, exp := range tokens {
+		if err := s.revocations.Revoke(jti, exp); err != nil {
+			log.Printf("Revoke token %s: %v", jti, err)
+		}
+		ids = append(ids, jti)
+	}
+	if s.hub == nil {
+		return
+	}
+	if n := s.hub.DisconnectTokens(ids...); n > 0 {
+		log.Printf("Closed %d sockets of revoked tokens", n)
+	}
+}
+
+// SignInHandler godoc
+// @Summary      Sign in and get tokens
+// @Description  Starts a session for a random user ID (demo only) and returns an access and a refresh token
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  tokenPair
+// @Failure      500  {object}  map[string]string  "error"
+// @Router       /signin [post]
+func (s *Server) SignInHandler(c *gin.Context) {
+	userID, _ := uuid.NewV4()
+	sid, _ := uuid.NewV4()
+	pair, access, refresh, err := s.signPair(userID, sid.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.sessions.Start(refresh.SessionID, sessionTokens(access, refresh)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pair)
+}
+
+// RefreshHandler godoc
+// @Summary      Refresh tokens
+// @Description  Exchanges a refresh token for a new pair. Each refresh token works once; presenting a used one revokes the session.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        body  body  refreshRequest  true  "Refresh token"
+// @Success      200  {object}  tokenPair
+// @Failure      400  {object}  map[string]string  "error"
+// @Failure      401  {object}  map[string]string  "error"
+// @Router       /token/refresh [post]
+func (s *Server) RefreshHandler(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+	old, err := s.refreshClaims(req.RefreshToken)
+	if err != nil {
+		log.Printf("Rejected refresh token: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+	pair, access, refresh, err := s.signPair(old.UserID, old.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sess, err := s.sessions.Rotate(old.SessionID, old.ID, sessionTokens(access, refresh))
+	if errors.Is(err, helper.ErrRefreshReused) {
+		log.Printf("Refresh token of session %s reused: revoking it", old.SessionID)
+		s.revoke(sess.Access)
+	}
+	switch {
+	case errors.Is(err, helper.ErrUnknownSession), errors.Is(err, helper.ErrRefreshReused):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		log.Printf("Refresh session %s: %v", old.SessionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "session store unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, pair)
+}
+
+// SignOutHandler godoc
+// @Summary      Sign out
+// @Description  Ends the session of the access token or of the refresh token in the body, revokes its tokens and closes its sockets
+// @Tags         auth
+// @Accept       json
+// @Security     BearerAuth
+// @Param        body  body  refreshRequest  false  "Refresh token, when the access token has expired"
+// @Success      204
+// @Failure      401  {object}  map[string]string  "error"
+// @Router       /signout [post]
+func (s *Server) SignOutHandler(c *gin.Context) {
+	var req refreshRequest
+	_ = c.ShouldBindJSON(&req) // the body is optional
+
+	revoked := make(map[string]time.Time)
+	sid := ""
+	if claims, ok := c.Get("claims"); ok {
+		access := claims.(constants.Claims)
+		sid = access.SessionID
+		if access.ID != "" {
+			revoked[access.ID] = time.Unix(access.ExpiresAt, 0)
+		}
+	}
+	if req.RefreshToken != "" {
+		refresh, err := s.refreshClaims(req.RefreshToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+		sid = refresh.SessionID
+	}
+	if sid == "" && len(revoked) == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	sess, err := s.sessions.End(sid)
+	if err != nil {
+		log.Printf("End session %s: %v", sid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "session store unavailable"})
+		return
+	}
+	if sess != nil {
+		for jti, exp := range sess.Access {
+			revoked[jti] = exp
+		}
+	}
+	s.revoke(revoked)
+	c.Status(http.StatusNoContent)
+}
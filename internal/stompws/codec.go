@@ -0,0 +1,58 @@
+// Package stompws speaks STOMP 1.2 over a WebSocket so browser clients such
+// as stomp.js can CONNECT, SUBSCRIBE and receive MESSAGE frames from the hub.
+package stompws
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Subprotocols are the WebSocket subprotocols offered by STOMP clients,
+// most preferred first.
+var Subprotocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+var errEmptyFrame = errors.New("stomp: empty frame")
+
+// Decode parses a single STOMP frame carried in one WebSocket message.
+// A nil frame with a nil error is a heart-beat.
+func Decode(data []byte) (*frame.Frame, error) {
+	if len(bytes.Trim(data, "\r\n")) == 0 {
+		return nil, nil
+	}
+	f, err := frame.NewReader(bytes.NewReader(data)).Read()
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, errEmptyFrame
+	}
+	return f, nil
+}
+
+// Encode serialises a frame, adding content-length when a body is present.
+func Encode(f *frame.Frame) []byte {
+	if len(f.Body) > 0 {
+		if _, ok := f.Header.Contains(frame.ContentLength); !ok {
+			f.Header.Set(frame.ContentLength, strconv.Itoa(len(f.Body)))
+		}
+	}
+	var buf bytes.Buffer
+	_ = frame.NewWriter(&buf).Write(f)
+	return buf.Bytes()
+}
+
+// errorFrame builds an ERROR frame; the connection must be closed after it.
+func errorFrame(message, receipt string) *frame.Frame {
+	f := frame.New(frame.ERROR,
+		frame.Message, message,
+		frame.ContentType, "text/plain",
+	)
+	if receipt != "" {
+		f.Header.Set(frame.ReceiptId, receipt)
+	}
+	f.Body = []byte(message)
+	return f
+}
@@ -0,0 +1,473 @@
+package stompws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gin-example/internal/hub"
+	"go-gin-example/internal/models"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+// Destinations understood by the session.
+const (
+	UserQueue        = "/user/queue/messages" // direct messages for the connected user
+	PresenceQueue    = "/user/queue/presence" // presence.online / presence.offline
+	SystemQueue      = "/user/queue/system"   // system.notice, session.resync
+	GroupTopicPrefix = "/topic/group."        // + groupID
+	AppPrefix        = "/app/"                // client → server SEND destinations
+)
+
+const (
+	serverName     = "go-gin-example"
+	maxFrameSize   = 64 * 1024
+	maxPendingAcks = 1024
+	// maxRedeliveries bounds how often a message the client NACKs, or
+	// leaves unacknowledged when it disconnects, is delivered again.
+	maxRedeliveries = 5
+	pongWait        = 60 * time.Second
+)
+
+const (
+	ackAuto             = "auto"
+	ackClient           = "client"
+	ackClientIndividual = "client-individual"
+)
+
+type state int
+
+const (
+	stateAwaitConnect state = iota
+	stateConnected
+	stateClosed
+)
+
+var errTransactions = errors.New("transactions are not supported")
+
+type subscription struct {
+	id          string
+	destination string
+	ack         string
+}
+
+// unacked is a MESSAGE frame awaiting its ACK or NACK.
+type unacked struct {
+	id    string // message-id
+	subID string
+	msg   *models.Message
+}
+
+// Session is the per-socket STOMP state machine. It is installed as the
+// client's hub.Codec so hub deliveries become MESSAGE frames on the
+// matching subscription.
+type Session struct {
+	hub    *hub.Hub
+	client *hub.Client
+
+	mu      sync.Mutex
+	state   state
+	version string
+	subs    map[string]*subscription // subscription id → subscription
+	pending []*unacked               // oldest first
+	acks    map[string]*unacked      // message-id → pending entry
+	seq     uint64
+}
+
+// NewSession binds a STOMP session to a hub client.
+func NewSession(h *hub.Hub, c *hub.Client) *Session {
+	s := &Session{
+		hub:    h,
+		client: c,
+		subs:   make(map[string]*subscription),
+		acks:   make(map[string]*unacked),
+	}
+	c.Codec = s
+	return s
+}
+
+// Destination returns the STOMP destination a hub message is published on.
+func Destination(msg *models.Message) string {
+	if strings.HasPrefix(msg.EventType, "presence.") {
+		return PresenceQueue
+	}
+	if msg.EventType == models.EventTypeSystemNotice || strings.HasPrefix(msg.EventType, "session.") {
+		return SystemQueue
+	}
+	if msg.GroupID != "" {
+		return GroupTopicPrefix + msg.GroupID
+	}
+	return UserQueue
+}
+
+// Encode implements hub.Codec. Group messages fall back to the user queue
+// when the client has not subscribed to the group topic.
+func (s *Session) Encode(msg *models.Message, data []byte) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != stateConnected {
+		return nil, false
+	}
+	dest := Destination(msg)
+	sub := s.subscriptionFor(dest)
+	if sub == nil && dest != UserQueue {
+		sub = s.subscriptionFor(UserQueue)
+	}
+	if sub == nil {
+		return nil, false
+	}
+	return Encode(s.message(sub, msg, data)), true
+}
+
+// message builds the MESSAGE frame for msg on sub and, unless the
+// subscription acknowledges automatically, tracks it until the client
+// settles it. Callers must hold s.mu.
+func (s *Session) message(sub *subscription, msg *models.Message, data []byte) *frame.Frame {
+	s.seq++
+	messageID := fmt.Sprintf("%s-%d", s.client.ID, s.seq)
+	f := frame.New(frame.MESSAGE,
+		frame.Subscription, sub.id,
+		frame.MessageId, messageID,
+		frame.Destination, sub.destination,
+		frame.ContentType, "application/json",
+	)
+	if sub.ack != ackAuto {
+		f.Header.Set(frame.Ack, messageID)
+		s.track(&unacked{id: messageID, subID: sub.id, msg: msg})
+	}
+	f.Body = data
+	return f
+}
+
+// Serve reads frames until the client disconnects or breaks the protocol,
+// then requeues the messages left unacknowledged and unregisters the
+// client. Serve never closes the socket: unregistering lets the write pump
+// flush what is buffered, including a trailing ERROR or RECEIPT frame,
+// before it closes the connection.
+func (s *Session) Serve() {
+	conn := s.client.Conn
+	conn.SetReadLimit(maxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		f, err := Decode(data)
+		if err != nil {
+			s.fail("malformed frame: "+err.Error(), "")
+			break
+		}
+		if f == nil {
+			continue // heart-beat
+		}
+		if !s.handle(f) {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	s.state = stateClosed
+	var left []*models.Message
+	for _, u := range s.pending {
+		if m, ok := s.retry(u); ok {
+			left = append(left, m)
+		}
+	}
+	s.pending, s.acks = nil, make(map[string]*unacked)
+	s.mu.Unlock()
+	s.hub.Requeue(s.client, left)
+	s.hub.Unregister(s.client)
+}
+
+// handle dispatches one frame and reports whether the session stays open.
+func (s *Session) handle(f *frame.Frame) bool {
+	receipt := f.Header.Get(frame.Receipt)
+
+	if f.Command == frame.CONNECT || f.Command == frame.STOMP {
+		if err := s.onConnect(f); err != nil {
+			s.fail(err.Error(), receipt)
+			return false
+		}
+		return true
+	}
+
+	s.mu.Lock()
+	connected := s.state == stateConnected
+	s.mu.Unlock()
+	if !connected {
+		s.fail("expected CONNECT frame, got "+f.Command, receipt)
+		return false
+	}
+
+	var err error
+	switch f.Command {
+	case frame.SUBSCRIBE:
+		err = s.onSubscribe(f)
+	case frame.UNSUBSCRIBE:
+		err = s.onUnsubscribe(f)
+	case frame.SEND:
+		err = s.onSend(f)
+	case frame.ACK, frame.NACK:
+		err = s.onAck(f)
+	case frame.BEGIN, frame.COMMIT, frame.ABORT:
+		err = errTransactions
+	case frame.DISCONNECT:
+		s.sendReceipt(receipt)
+		return false
+	default:
+		err = fmt.Errorf("unsupported command %s", f.Command)
+	}
+	if err != nil {
+		s.fail(err.Error(), receipt)
+		return false
+	}
+	s.sendReceipt(receipt)
+	return true
+}
+
+func (s *Session) onConnect(f *frame.Frame) error {
+	// Hub calls first: the hub locks before the session when delivering.
+	var epoch string
+	if s.hub != nil {
+		cur, err := hub.ParseCursor(f.Header.Get("epoch"), f.Header.Get("last-seq"))
+		if err != nil {
+			return err
+		}
+		if cur != nil {
+			s.hub.ResumeFrom(s.client, *cur)
+		}
+		epoch = s.hub.ResumeCursor(s.client.UserID).Epoch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state != stateAwaitConnect {
+		return errors.New("already connected")
+	}
+	version := negotiateVersion(f.Header.Get(frame.AcceptVersion))
+	if version == "" {
+		return errors.New("supported protocol versions are 1.0 1.1 1.2")
+	}
+	s.version = version
+	s.state = stateConnected
+
+	connected := frame.New(frame.CONNECTED,
+		frame.Version, version,
+		frame.HeartBeat, "0,0", // liveness is handled by WebSocket ping/pong
+		frame.Server, serverName,
+		frame.Session, s.client.ID,
+		"user-name", s.client.UserID,
+	)
+	if epoch != "" {
+		connected.Header.Set("epoch", epoch) // pass back with last-seq to resume
+	}
+	s.write(connected)
+	log.Printf("STOMP %s connected: %s (UserID=%s)", version, s.client.ID, s.client.UserID)
+	return nil
+}
+
+func (s *Session) onSubscribe(f *frame.Frame) error {
+	id := f.Header.Get(frame.Id)
+	dest := f.Header.Get(frame.Destination)
+	if id == "" || dest == "" {
+		return errors.New("SUBSCRIBE requires id and destination headers")
+	}
+	ack := f.Header.Get(frame.Ack)
+	if ack == "" {
+		ack = ackAuto
+	}
+	if ack != ackAuto && ack != ackClient && ack != ackClientIndividual {
+		return fmt.Errorf("invalid ack mode %q", ack)
+	}
+	if err := s.authorize(dest); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if _, ok := s.subs[id]; ok {
+		s.mu.Unlock()
+		return fmt.Errorf("subscription %q already exists", id)
+	}
+	s.subs[id] = &subscription{id: id, destination: dest, ack: ack}
+	s.mu.Unlock()
+
+	if dest == UserQueue && s.hub != nil {
+		// Messages stored while the user was offline need this subscription.
+		s.hub.CatchUp(s.client)
+	}
+	return nil
+}
+
+func (s *Session) onUnsubscribe(f *frame.Frame) error {
+	id := f.Header.Get(frame.Id)
+	if id == "" {
+		return errors.New("UNSUBSCRIBE requires an id header")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, id)
+	return nil
+}
+
+func (s *Session) onSend(f *frame.Frame) error {
+	dest := f.Header.Get(frame.Destination)
+	if !strings.HasPrefix(dest, AppPrefix) {
+		return fmt.Errorf("cannot SEND to %q", dest)
+	}
+
+	_, err := s.hub.HandleInbound(s.client, f.Body)
+	if errors.Is(err, hub.ErrRateLimited) {
+		return nil // throttled typing/read events are dropped quietly
+	}
+	return err
+}
+
+func (s *Session) onAck(f *frame.Frame) error {
+	id := f.Header.Get(frame.Id)
+	if id == "" {
+		return fmt.Errorf("%s requires an id header", f.Command)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.acks[id]
+	if !ok {
+		return nil // already settled or never tracked
+	}
+	sub := s.subs[u.subID]
+	settled := s.settle(id, u.subID, sub != nil && sub.ack == ackClient)
+	if f.Command != frame.NACK || sub == nil {
+		return nil
+	}
+	for _, u := range settled {
+		if m, ok := s.retry(u); ok {
+			data, _ := json.Marshal(m)
+			s.write(s.message(sub, m, data))
+		}
+	}
+	return nil
+}
+
+// retry returns a copy of u's message counting one more redelivery, or
+// false once the message was redelivered maxRedeliveries times. Callers
+// must hold s.mu.
+func (s *Session) retry(u *unacked) (*models.Message, bool) {
+	if u.msg.Redeliveries >= maxRedeliveries {
+		log.Printf("STOMP: dropping %s for %s after %d redeliveries", u.msg.ID, s.client.ID, u.msg.Redeliveries)
+		return nil, false
+	}
+	m := *u.msg
+	m.Redeliveries++
+	return &m, true
+}
+
+// authorize checks that the client may subscribe to dest.
+func (s *Session) authorize(dest string) error {
+	if dest == UserQueue || dest == PresenceQueue || dest == SystemQueue {
+		return nil
+	}
+	if groupID, ok := strings.CutPrefix(dest, GroupTopicPrefix); ok && groupID != "" {
+		if s.hub.IsGroupMember(groupID, s.client.UserID) {
+			return nil
+		}
+		return fmt.Errorf("not a member of group %s", groupID)
+	}
+	return fmt.Errorf("unknown destination %q", dest)
+}
+
+// subscriptionFor returns a subscription on dest. Callers must hold s.mu.
+func (s *Session) subscriptionFor(dest string) *subscription {
+	for _, sub := range s.subs {
+		if sub.destination == dest {
+			return sub
+		}
+	}
+	return nil
+}
+
+// track remembers an unacknowledged message, forgetting the oldest once
+// the window is full. Callers must hold s.mu.
+func (s *Session) track(u *unacked) {
+	if len(s.pending) >= maxPendingAcks {
+		log.Printf("STOMP ack window of %s full: forgetting %s", s.client.ID, s.pending[0].id)
+		delete(s.acks, s.pending[0].id)
+		s.pending = s.pending[1:]
+	}
+	s.pending = append(s.pending, u)
+	s.acks[u.id] = u
+}
+
+// settle removes messageID from the pending window and returns the entries
+// it settled, oldest first. In cumulative ("client") mode every earlier
+// message on the same subscription is settled too. Callers must hold s.mu.
+func (s *Session) settle(messageID, subID string, cumulative bool) []*unacked {
+	var settled []*unacked
+	kept := s.pending[:0]
+	done := false
+	for _, u := range s.pending {
+		match := u.id == messageID || (cumulative && !done && u.subID == subID)
+		if u.id == messageID {
+			done = true
+		}
+		if match {
+			delete(s.acks, u.id)
+			settled = append(settled, u)
+			continue
+		}
+		kept = append(kept, u)
+	}
+	s.pending = kept
+	return settled
+}
+
+func (s *Session) sendReceipt(receipt string) {
+	if receipt == "" {
+		return
+	}
+	s.write(frame.New(frame.RECEIPT, frame.ReceiptId, receipt))
+}
+
+// fail sends an ERROR frame; the caller must end the session afterwards.
+func (s *Session) fail(message, receipt string) {
+	log.Printf("STOMP error for %s: %s", s.client.ID, message)
+	s.write(errorFrame(message, receipt))
+}
+
+func (s *Session) write(f *frame.Frame) {
+	select {
+	case s.client.Send <- Encode(f):
+	default:
+		log.Printf("Buffer full: dropping %s frame for %s", f.Command, s.client.UserID)
+	}
+}
+
+// negotiateVersion picks the highest protocol version both sides support.
+// A missing accept-version header means STOMP 1.0.
+func negotiateVersion(accept string) string {
+	if accept == "" {
+		return "1.0"
+	}
+	offered := make(map[string]bool)
+	for _, v := range strings.Split(accept, ",") {
+		offered[strings.TrimSpace(v)] = true
+	}
+	for _, v := range []string{"1.2", "1.1", "1.0"} {
+		if offered[v] {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package stompws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go-gin-example/internal/hub"
+	"go-gin-example/internal/models"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+func nextFrame(t *testing.T, c *hub.Client) *frame.Frame {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		f, err := Decode(data)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return f
+	default:
+		t.Fatal("no frame written")
+		return nil
+	}
+}
+
+func TestSessionConnectSubscribeMessage(t *testing.T) {
+	c := &hub.Client{ID: "c1", UserID: "alice", Send: make(chan []byte, 16)}
+	s := NewSession(nil, c)
+
+	if !s.handle(frame.New(frame.CONNECT, frame.AcceptVersion, "1.1,1.2", frame.Host, "localhost")) {
+		t.Fatal("CONNECT closed the session")
+	}
+	if f := nextFrame(t, c); f.Command != frame.CONNECTED || f.Header.Get(frame.Version) != "1.2" {
+		t.Fatalf("got %s version=%q, want CONNECTED 1.2", f.Command, f.Header.Get(frame.Version))
+	}
+
+	sub := frame.New(frame.SUBSCRIBE, frame.Id, "sub-0", frame.Destination, UserQueue, frame.Receipt, "r1")
+	if !s.handle(sub) {
+		t.Fatal("SUBSCRIBE closed the session")
+	}
+	if f := nextFrame(t, c); f.Command != frame.RECEIPT || f.Header.Get(frame.ReceiptId) != "r1" {
+		t.Fatalf("got %s, want RECEIPT r1", f.Command)
+	}
+
+	data, ok := s.Encode(&models.Message{RecipientID: "alice", GroupID: "g1"}, []byte(`{"content":"hi"}`))
+	if !ok {
+		t.Fatal("group message not delivered via user queue fallback")
+	}
+	f, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Command != frame.MESSAGE || f.Header.Get(frame.Subscription) != "sub-0" || string(f.Body) != `{"content":"hi"}` {
+		t.Fatalf("unexpected frame %s %v %q", f.Command, f.Header, f.Body)
+	}
+}
+
+func TestSessionRequiresConnect(t *testing.T) {
+	c := &hub.Client{ID: "c1", UserID: "alice", Send: make(chan []byte, 16)}
+	s := NewSession(nil, c)
+
+	if s.handle(frame.New(frame.SUBSCRIBE, frame.Id, "0", frame.Destination, UserQueue)) {
+		t.Fatal("SUBSCRIBE before CONNECT kept the session open")
+	}
+	if f := nextFrame(t, c); f.Command != frame.ERROR {
+		t.Fatalf("got %s, want ERROR", f.Command)
+	}
+	if _, ok := s.Encode(&models.Message{RecipientID: "alice"}, []byte("{}")); ok {
+		t.Fatal("unconnected session accepted a delivery")
+	}
+}
+
+func TestClientAckIsCumulative(t *testing.T) {
+	c := &hub.Client{ID: "c1", UserID: "alice", Send: make(chan []byte, 16)}
+	s := NewSession(nil, c)
+	s.handle(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+	s.handle(frame.New(frame.SUBSCRIBE, frame.Id, "0", frame.Destination, UserQueue, frame.Ack, ackClient))
+
+	for i := 0; i < 3; i++ {
+		s.Encode(&models.Message{RecipientID: "alice"}, []byte("{}"))
+	}
+	s.handle(frame.New(frame.ACK, frame.Id, "c1-2"))
+
+	if len(s.pending) != 1 || s.pending[0].id != "c1-3" {
+		t.Fatalf("pending = %v, want [c1-3]", s.pending)
+	}
+}
+
+func TestNackRedeliversUpToTheLimit(t *testing.T) {
+	c := &hub.Client{ID: "c1", UserID: "alice", Send: make(chan []byte, 16)}
+	s := NewSession(nil, c)
+	s.handle(frame.New(frame.CONNECT, frame.AcceptVersion, "1.2"))
+	s.handle(frame.New(frame.SUBSCRIBE, frame.Id, "0", frame.Destination, UserQueue, frame.Ack, ackClientIndividual))
+	nextFrame(t, c) // CONNECTED
+
+	data, _ := s.Encode(&models.Message{ID: "m1", RecipientID: "alice"}, []byte("{}"))
+	f, _ := Decode(data)
+	for i := 1; i <= maxRedeliveries; i++ {
+		s.handle(frame.New(frame.NACK, frame.Id, f.Header.Get(frame.Ack)))
+		f = nextFrame(t, c)
+		var m models.Message
+		if err := json.Unmarshal(f.Body, &m); err != nil || m.ID != "m1" || m.Redeliveries != i {
+			t.Fatalf("redelivery %d: %q (%v)", i, f.Body, err)
+		}
+	}
+
+	s.handle(frame.New(frame.NACK, frame.Id, f.Header.Get(frame.Ack)))
+	select {
+	case data := <-c.Send:
+		t.Fatalf("redelivered past the limit: %q", data)
+	default:
+	}
+	if len(s.pending) != 0 {
+		t.Fatalf("pending = %v after the last NACK", s.pending)
+	}
+}